@@ -0,0 +1,192 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// CBC3-HMAC is an encrypt-then-MAC AEAD built the same way as the
+// AES_CBC_HMAC_SHA2 algorithms of RFC 7518 section 5.2, with the single CBC
+// layer replaced by CBC3.  The key is MAC_KEY || ENC_KEY, ENC_KEY is split
+// into the three stage keys, the triple IV is the nonce, and the tag is the
+// truncated HMAC of A || IV || C || AL.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+var (
+	errOpen       = errors.New("cbc3: message authentication failed")
+	errKeySize    = errors.New("cbc3: invalid key size")
+	errBlockSizes = errors.New("cbc3: BlockSize must be equal for all three block ciphers")
+)
+
+type cbc3HMAC struct {
+	b1, b2, b3 cipher.Block
+	blockSize  int
+	macKey     []byte
+	hash       func() hash.Hash
+	tagSize    int
+}
+
+// NewCBC3HMAC returns a cipher.AEAD which encrypts with CBC3 and
+// authenticates with HMAC in the encrypt-then-MAC order.  The first macKeySize
+// bytes of key are used as the HMAC key, and the remainder is split into three
+// equal stage keys which are handed to newCipher to build b1, b2 and b3.  The
+// tag is the leading tagSize bytes of the HMAC output.
+//
+// The nonce is the triple IV.  As with any CBC IV it must be random, drawn
+// fresh from crypto/rand for each message sealed under the same key; a nonce
+// that is unique but predictable, such as a counter, is not enough.
+func NewCBC3HMAC(newCipher func(key []byte) (cipher.Block, error), h func() hash.Hash, key []byte, macKeySize, tagSize int) (cipher.AEAD, error) {
+	if tagSize <= 0 || tagSize > h().Size() {
+		return nil, errors.New("cbc3: invalid tag size")
+	}
+	if macKeySize <= 0 || len(key) <= macKeySize || (len(key)-macKeySize)%3 != 0 {
+		return nil, errKeySize
+	}
+	encKey := key[macKeySize:]
+	n := len(encKey) / 3
+	b1, err := newCipher(encKey[:n])
+	if err != nil {
+		return nil, err
+	}
+	b2, err := newCipher(encKey[n : 2*n])
+	if err != nil {
+		return nil, err
+	}
+	b3, err := newCipher(encKey[2*n:])
+	if err != nil {
+		return nil, err
+	}
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		return nil, errBlockSizes
+	}
+	return &cbc3HMAC{
+		b1:        b1,
+		b2:        b2,
+		b3:        b3,
+		blockSize: bs,
+		macKey:    dup(key[:macKeySize]),
+		hash:      h,
+		tagSize:   tagSize,
+	}, nil
+}
+
+// NewCBC3HMACSHA256 returns the CBC3 analogue of A128CBC-HS256: a 16 byte MAC
+// key, HMAC-SHA-256 and a 16 byte tag.  The key is the MAC key followed by the
+// three stage keys.
+func NewCBC3HMACSHA256(newCipher func(key []byte) (cipher.Block, error), key []byte) (cipher.AEAD, error) {
+	return NewCBC3HMAC(newCipher, sha256.New, key, 16, 16)
+}
+
+// NewCBC3HMACSHA384 returns the CBC3 analogue of A192CBC-HS384: a 24 byte MAC
+// key, HMAC-SHA-384 and a 24 byte tag.  The key is the MAC key followed by the
+// three stage keys.
+func NewCBC3HMACSHA384(newCipher func(key []byte) (cipher.Block, error), key []byte) (cipher.AEAD, error) {
+	return NewCBC3HMAC(newCipher, sha512.New384, key, 24, 24)
+}
+
+// NewCBC3HMACSHA512 returns the CBC3 analogue of A256CBC-HS512: a 32 byte MAC
+// key, HMAC-SHA-512 and a 32 byte tag.  The key is the MAC key followed by the
+// three stage keys.
+func NewCBC3HMACSHA512(newCipher func(key []byte) (cipher.Block, error), key []byte) (cipher.AEAD, error) {
+	return NewCBC3HMAC(newCipher, sha512.New, key, 32, 32)
+}
+
+func (a *cbc3HMAC) NonceSize() int { return 3 * a.blockSize }
+
+func (a *cbc3HMAC) Overhead() int { return a.blockSize + a.tagSize }
+
+func (a *cbc3HMAC) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != a.NonceSize() {
+		panic("cbc3: incorrect nonce length given to CBC3-HMAC")
+	}
+	n := len(plaintext) + a.blockSize - len(plaintext)%a.blockSize
+	ret, out := sliceForAppend(dst, n+a.tagSize)
+	if inexactOverlap(out, plaintext) {
+		panic("cbc3: invalid buffer overlap")
+	}
+
+	copy(out, plaintext)
+	pkcs7Pad(out[len(plaintext):n])
+	NewEncrypter(a.b1, a.b2, a.b3, nonce).CryptBlocks(out[:n], out[:n])
+
+	copy(out[n:], a.tag(nonce, out[:n], additionalData))
+	return ret
+}
+
+func (a *cbc3HMAC) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != a.NonceSize() {
+		panic("cbc3: incorrect nonce length given to CBC3-HMAC")
+	}
+	if len(ciphertext) < a.blockSize+a.tagSize {
+		return nil, errOpen
+	}
+	n := len(ciphertext) - a.tagSize
+	if n%a.blockSize != 0 {
+		return nil, errOpen
+	}
+	if !hmac.Equal(ciphertext[n:], a.tag(nonce, ciphertext[:n], additionalData)) {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, n)
+	if inexactOverlap(out, ciphertext) {
+		panic("cbc3: invalid buffer overlap")
+	}
+	NewDecrypter(a.b1, a.b2, a.b3, nonce).CryptBlocks(out, ciphertext[:n])
+
+	unpadded, err := pkcs7Unpad(out, a.blockSize)
+	if err != nil {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret[:len(ret)-n+len(unpadded)], nil
+}
+
+// tag computes the truncated HMAC over A || IV || C || AL, where AL is the
+// bit length of the additional data as a 64 bit big-endian integer.
+func (a *cbc3HMAC) tag(nonce, ciphertext, additionalData []byte) []byte {
+	mac := hmac.New(a.hash, a.macKey)
+	mac.Write(additionalData)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	var al [8]byte
+	binary.BigEndian.PutUint64(al[:], uint64(len(additionalData))*8)
+	mac.Write(al[:])
+	return mac.Sum(nil)[:a.tagSize]
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes. If the
+// original slice has sufficient capacity then no allocation is performed.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
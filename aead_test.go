@@ -0,0 +1,164 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestCBC3HMACRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		newAEAD   func(func([]byte) (cipher.Block, error), []byte) (cipher.AEAD, error)
+		newCipher func([]byte) (cipher.Block, error)
+		keySize   int
+	}{
+		{"DES-SHA256", cbc3.NewCBC3HMACSHA256, des.NewCipher, 16 + 3*8},
+		{"AES128-SHA256", cbc3.NewCBC3HMACSHA256, aes.NewCipher, 16 + 3*16},
+		{"AES192-SHA384", cbc3.NewCBC3HMACSHA384, aes.NewCipher, 24 + 3*24},
+		{"AES256-SHA512", cbc3.NewCBC3HMACSHA512, aes.NewCipher, 32 + 3*32},
+	}
+	for _, tc := range tests {
+		key := make([]byte, tc.keySize)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		aead, err := tc.newAEAD(tc.newCipher, key)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		nonce := make([]byte, aead.NonceSize())
+		for i := range nonce {
+			nonce[i] = byte(0xa0 + i)
+		}
+		ad := []byte("additional data")
+		for n := 0; n < 50; n++ {
+			plaintext := bytes.Repeat([]byte{'x'}, n)
+			sealed := aead.Seal(nil, nonce, plaintext, ad)
+			if len(sealed) > n+aead.Overhead() {
+				t.Errorf("%s: sealed length %d exceeds overhead", tc.name, len(sealed))
+			}
+			opened, err := aead.Open(nil, nonce, sealed, ad)
+			if err != nil {
+				t.Fatalf("%s: open %d bytes: %s", tc.name, n, err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Errorf("%s: round trip mismatch at %d bytes", tc.name, n)
+			}
+		}
+	}
+}
+
+func TestCBC3HMACTamper(t *testing.T) {
+	key := make([]byte, 16+3*16)
+	aead, err := cbc3.NewCBC3HMACSHA256(aes.NewCipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	ad := []byte("header")
+	sealed := aead.Seal(nil, nonce, []byte("exampleplaintext"), ad)
+
+	for i := range sealed {
+		bad := append([]byte{}, sealed...)
+		bad[i] ^= 1
+		if _, err := aead.Open(nil, nonce, bad, ad); err == nil {
+			t.Errorf("flipped byte %d was not detected", i)
+		}
+	}
+	badNonce := append([]byte{}, nonce...)
+	badNonce[len(badNonce)-1] ^= 1
+	if _, err := aead.Open(nil, badNonce, sealed, ad); err == nil {
+		t.Errorf("modified nonce was not detected")
+	}
+	if _, err := aead.Open(nil, nonce, sealed, []byte("Header")); err == nil {
+		t.Errorf("modified additional data was not detected")
+	}
+	if _, err := aead.Open(nil, nonce, sealed[:len(sealed)-1], ad); err == nil {
+		t.Errorf("truncated ciphertext was not detected")
+	}
+}
+
+func TestCBC3HMACConstruction(t *testing.T) {
+	// Rebuild the RFC 7518 recipe by hand from the CBC3 mode and crypto/hmac
+	// and check that the AEAD produces the same bytes.
+	key := make([]byte, 16+3*8)
+	for i := range key {
+		key[i] = byte(3 * i)
+	}
+	aead, err := cbc3.NewCBC3HMACSHA256(des.NewCipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 24)
+	for i := range nonce {
+		nonce[i] = byte(i)
+	}
+	plaintext := []byte("exampleplaintext")
+	ad := []byte("The second principle of Auguste Kerckhoffs")
+
+	b1, _ := des.NewCipher(key[16:24])
+	b2, _ := des.NewCipher(key[24:32])
+	b3, _ := des.NewCipher(key[32:40])
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{8}, 8)...)
+	ciphertext := make([]byte, len(padded))
+	cbc3.NewEncrypter(b1, b2, b3, nonce).CryptBlocks(ciphertext, padded)
+
+	mac := hmac.New(sha256.New, key[:16])
+	mac.Write(ad)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	binary.Write(mac, binary.BigEndian, uint64(len(ad)*8))
+	want := append(ciphertext, mac.Sum(nil)[:16]...)
+
+	if got := aead.Seal(nil, nonce, plaintext, ad); !bytes.Equal(got, want) {
+		t.Errorf("Seal mismatch:\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestCBC3HMACBadPadding(t *testing.T) {
+	// A message with a valid tag but broken padding must fail to open and
+	// leave no plaintext behind in dst.
+	key := make([]byte, 16+3*8)
+	aead, err := cbc3.NewCBC3HMACSHA256(des.NewCipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 24)
+	b1, _ := des.NewCipher(key[16:24])
+	b2, _ := des.NewCipher(key[24:32])
+	b3, _ := des.NewCipher(key[32:40])
+	padded := []byte("exampleplaintext")
+	ciphertext := make([]byte, len(padded))
+	cbc3.NewEncrypter(b1, b2, b3, nonce).CryptBlocks(ciphertext, padded)
+
+	mac := hmac.New(sha256.New, key[:16])
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	binary.Write(mac, binary.BigEndian, uint64(0))
+	sealed := append(ciphertext, mac.Sum(nil)[:16]...)
+
+	dst := make([]byte, 4, 4+len(ciphertext))
+	copy(dst, "head")
+	if _, err := aead.Open(dst, nonce, sealed, nil); err == nil {
+		t.Fatal("broken padding was accepted")
+	}
+	if rest := dst[:cap(dst)]; string(rest[:4]) != "head" || !bytes.Equal(rest[4:], make([]byte, len(ciphertext))) {
+		t.Errorf("dst holds %q after a failed Open", rest)
+	}
+}
+
+func TestCBC3HMACKeySize(t *testing.T) {
+	for _, n := range []int{0, 16, 16 + 8, 16 + 3*8 + 1} {
+		if _, err := cbc3.NewCBC3HMACSHA256(des.NewCipher, make([]byte, n)); err == nil {
+			t.Errorf("key of %d bytes was accepted", n)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/cast5"
+)
+
+// Algorithm describes a block cipher which can be used for a CBC3 stage when
+// it has to be chosen by name, as in test vectors and on the command line.
+type Algorithm struct {
+	Name      string
+	KeySize   int // default key size in bytes
+	BlockSize int
+	New       func(key []byte) (cipher.Block, error)
+}
+
+var algorithms = struct {
+	sync.RWMutex
+	m map[string]Algorithm
+}{m: make(map[string]Algorithm)}
+
+func init() {
+	for _, a := range []Algorithm{
+		{"des", 8, des.BlockSize, des.NewCipher},
+		{"aes-128", 16, aes.BlockSize, aes.NewCipher},
+		{"aes-192", 24, aes.BlockSize, aes.NewCipher},
+		{"aes-256", 32, aes.BlockSize, aes.NewCipher},
+		{"blowfish", 16, blowfish.BlockSize, func(key []byte) (cipher.Block, error) { return blowfish.NewCipher(key) }},
+		{"cast5", cast5.KeySize, cast5.BlockSize, func(key []byte) (cipher.Block, error) { return cast5.NewCipher(key) }},
+	} {
+		RegisterAlgorithm(a)
+	}
+}
+
+// RegisterAlgorithm makes a block cipher available by name, replacing any
+// earlier registration of the same name.
+func RegisterAlgorithm(a Algorithm) {
+	algorithms.Lock()
+	defer algorithms.Unlock()
+	algorithms.m[a.Name] = a
+}
+
+// LookupAlgorithm returns the registered algorithm called name.  The names of
+// the built in ones are des, aes-128, aes-192, aes-256, blowfish and cast5.
+func LookupAlgorithm(name string) (Algorithm, bool) {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+	a, ok := algorithms.m[name]
+	return a, ok
+}
+
+// Algorithms returns the names of all registered algorithms, sorted.
+func Algorithms() []string {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+	names := make([]string, 0, len(algorithms.m))
+	for n := range algorithms.m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,273 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Configuration analysis.  CBC3 runs its stages in the direction pattern
+// encrypt (b1), decrypt (b2), encrypt (b3).  Between b1 and b2 there is no XOR,
+// the output of b1 goes straight into the inverse of b2, so when the two
+// stages share a key they cancel and from the second block on CBC3 is no more
+// than single CBC under b3.  If the first two IV registers are also equal
+// even the first block matches single CBC.  Between b2 and b3 the IV XORs
+// keep the stages apart, which is why SSH-1 can reuse K1 as K3.
+
+package cbc3
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Severity ranks a Finding.
+type Severity int
+
+const (
+	// Info findings describe the configuration but do not weaken it.
+	Info Severity = iota
+	// Warning findings weaken the configuration.
+	Warning
+	// Critical findings make the configuration degenerate.
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Finding is a single observation about a CBC3 configuration.
+type Finding struct {
+	Severity Severity
+	Code     string // short stable identifier, such as "equal-k1-k2"
+	Message  string
+}
+
+func (f Finding) String() string {
+	return f.Severity.String() + ": " + f.Message
+}
+
+// Report lists the findings of Analyze.
+type Report struct {
+	Findings []Finding
+}
+
+func (r *Report) add(s Severity, code, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: s, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Max returns the highest severity in the report, or -1 when it is empty.
+func (r *Report) Max() Severity {
+	max := Severity(-1)
+	for _, f := range r.Findings {
+		if f.Severity > max {
+			max = f.Severity
+		}
+	}
+	return max
+}
+
+// Err returns an error listing every Warning or Critical finding, or nil if
+// there are none.
+func (r *Report) Err() error {
+	var msgs []string
+	for _, f := range r.Findings {
+		if f.Severity >= Warning {
+			msgs = append(msgs, f.Message)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New("cbc3: unsafe configuration: " + strings.Join(msgs, "; "))
+}
+
+// Analyze inspects the stage keys of ks, built into ciphers with newCipher,
+// together with the triple IV (ks.IV when iv is nil).  It reports stages which
+// cancel each other in the encrypt-decrypt-encrypt pattern, DES weak and
+// semi-weak keys in stages which are DES or triple DES, and all-zero or repeated IV registers.  Stage keys are
+// compared by their behaviour, so DES keys which differ only in their parity
+// bits are recognized as equal.
+func Analyze(ks *KeySet, newCipher func(key []byte) (cipher.Block, error), iv []byte) (*Report, error) {
+	b1, b2, b3, err := ks.blocksIV(newCipher, &iv)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	bs := b1.BlockSize()
+
+	same12, same23, same13 := sameKey(b1, b2), sameKey(b2, b3), sameKey(b1, b3)
+	switch {
+	case same12 && same23:
+		r.add(Critical, "equal-keys", "all three stage keys are equal, CBC3 collapses to single CBC")
+	case same12 && bytes.Equal(iv[:bs], iv[bs:2*bs]):
+		r.add(Critical, "equal-k1-k2", "K1 equals K2 and the first two IV registers are equal, b1 and b2 cancel and CBC3 is exactly single CBC under K3")
+	case same12:
+		r.add(Critical, "equal-k1-k2", "K1 equals K2, b1 and b2 cancel and CBC3 is single CBC under K3 after the first block")
+	case same23:
+		r.add(Warning, "equal-k2-k3", "K2 equals K3, leaving only two independent stage keys")
+	}
+	if same13 && !same12 {
+		r.add(Info, "equal-k1-k3", "K1 equals K3, as in SSH-1 3DES; the stages do not cancel but only two keys are independent")
+	}
+
+	blocks := []cipher.Block{b1, b2, b3}
+	for i, k := range [][]byte{ks.K1, ks.K2, ks.K3} {
+		if bs != 8 || !isDES(blocks[i], k) {
+			continue
+		}
+		for j := 0; j+8 <= len(k) && j < 24; j += 8 {
+			switch desKeyClass(k[j : j+8]) {
+			case desWeak:
+				r.add(Critical, "des-weak-key", "K%d holds the DES weak key %x", i+1, k[j:j+8])
+			case desSemiWeak:
+				r.add(Critical, "des-semi-weak-key", "K%d holds the DES semi-weak key %x", i+1, k[j:j+8])
+			}
+		}
+	}
+
+	zero := make([]byte, bs)
+	for i := 0; i < 3; i++ {
+		if bytes.Equal(iv[i*bs:(i+1)*bs], zero) {
+			r.add(Warning, "zero-iv", "IV register %d is all zeros", i+1)
+		}
+	}
+	if bytes.Equal(iv[:bs], iv[bs:2*bs]) || bytes.Equal(iv[bs:2*bs], iv[2*bs:]) || bytes.Equal(iv[:bs], iv[2*bs:]) {
+		r.add(Info, "repeated-iv", "two IV registers hold the same value")
+	}
+	return r, nil
+}
+
+// Validate runs Analyze and returns an error if it reports anything of
+// Warning severity or above.
+func Validate(ks *KeySet, newCipher func(key []byte) (cipher.Block, error), iv []byte) error {
+	r, err := Analyze(ks, newCipher, iv)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
+
+// NewStrictEncrypter is like NewEncrypter but refuses any configuration which
+// Validate rejects.
+func (ks *KeySet) NewStrictEncrypter(newCipher func(key []byte) (cipher.Block, error), iv []byte) (cipher.BlockMode, error) {
+	if err := Validate(ks, newCipher, iv); err != nil {
+		return nil, err
+	}
+	return ks.NewEncrypter(newCipher, iv)
+}
+
+// NewStrictDecrypter is like NewDecrypter but refuses any configuration which
+// Validate rejects.
+func (ks *KeySet) NewStrictDecrypter(newCipher func(key []byte) (cipher.Block, error), iv []byte) (cipher.BlockMode, error) {
+	if err := Validate(ks, newCipher, iv); err != nil {
+		return nil, err
+	}
+	return ks.NewDecrypter(newCipher, iv)
+}
+
+// sameKey reports whether two blocks compute the same permutation, by
+// checking that decrypting with b reverses encrypting with a on a few probe
+// blocks.
+func sameKey(a, b cipher.Block) bool {
+	bs := a.BlockSize()
+	probe, out := make([]byte, bs), make([]byte, bs)
+	for i := 0; i < 3; i++ {
+		for j := range probe {
+			probe[j] = byte(i*0x5b + j*0x3d)
+		}
+		a.Encrypt(out, probe)
+		b.Decrypt(out, out)
+		if !bytes.Equal(out, probe) {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	desNormal = iota
+	desWeak
+	desSemiWeak
+)
+
+// DES weak and semi-weak keys, with the parity bits set (FIPS 74 section 3.6).
+var desWeakKeys = [][8]byte{
+	{0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01},
+	{0xfe, 0xfe, 0xfe, 0xfe, 0xfe, 0xfe, 0xfe, 0xfe},
+	{0xe0, 0xe0, 0xe0, 0xe0, 0xf1, 0xf1, 0xf1, 0xf1},
+	{0x1f, 0x1f, 0x1f, 0x1f, 0x0e, 0x0e, 0x0e, 0x0e},
+}
+
+var desSemiWeakKeys = [][8]byte{
+	{0x01, 0xfe, 0x01, 0xfe, 0x01, 0xfe, 0x01, 0xfe}, {0xfe, 0x01, 0xfe, 0x01, 0xfe, 0x01, 0xfe, 0x01},
+	{0x1f, 0xe0, 0x1f, 0xe0, 0x0e, 0xf1, 0x0e, 0xf1}, {0xe0, 0x1f, 0xe0, 0x1f, 0xf1, 0x0e, 0xf1, 0x0e},
+	{0x01, 0xe0, 0x01, 0xe0, 0x01, 0xf1, 0x01, 0xf1}, {0xe0, 0x01, 0xe0, 0x01, 0xf1, 0x01, 0xf1, 0x01},
+	{0x1f, 0xfe, 0x1f, 0xfe, 0x0e, 0xfe, 0x0e, 0xfe}, {0xfe, 0x1f, 0xfe, 0x1f, 0xfe, 0x0e, 0xfe, 0x0e},
+	{0x01, 0x1f, 0x01, 0x1f, 0x01, 0x0e, 0x01, 0x0e}, {0x1f, 0x01, 0x1f, 0x01, 0x0e, 0x01, 0x0e, 0x01},
+	{0xe0, 0xfe, 0xe0, 0xfe, 0xf1, 0xfe, 0xf1, 0xfe}, {0xfe, 0xe0, 0xfe, 0xe0, 0xfe, 0xf1, 0xfe, 0xf1},
+}
+
+// isDES reports whether b is DES or triple DES under key, by comparing it with
+// the crypto/des cipher built from the same key.  Other ciphers with 64 bit
+// blocks, such as Blowfish or CAST5, have no DES weak keys.
+func isDES(b cipher.Block, key []byte) bool {
+	var d cipher.Block
+	var err error
+	switch len(key) {
+	case 8:
+		d, err = des.NewCipher(key)
+	case 16:
+		d, err = des.NewTripleDESCipher(append(append([]byte{}, key...), key[:8]...))
+	case 24:
+		d, err = des.NewTripleDESCipher(key)
+	default:
+		return false
+	}
+	return err == nil && sameKey(b, d)
+}
+
+// desKeyClass classifies an 8 byte DES key, ignoring the parity bits.
+func desKeyClass(key []byte) int {
+	match := func(list [][8]byte) bool {
+		for _, w := range list {
+			eq := true
+			for i := range w {
+				if key[i]&0xfe != w[i]&0xfe {
+					eq = false
+					break
+				}
+			}
+			if eq {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case match(desWeakKeys):
+		return desWeak
+	case match(desSemiWeakKeys):
+		return desSemiWeak
+	}
+	return desNormal
+}
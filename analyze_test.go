@@ -0,0 +1,121 @@
+package cbc3_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/hex"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+	"golang.org/x/crypto/blowfish"
+)
+
+func codes(r *cbc3.Report) map[string]cbc3.Severity {
+	m := map[string]cbc3.Severity{}
+	for _, f := range r.Findings {
+		m[f.Code] = f.Severity
+	}
+	return m
+}
+
+func hexKey(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func TestAnalyze(t *testing.T) {
+	iv := []byte("0123456789abcdefghijklmn")
+	tests := []struct {
+		name   string
+		ks     *cbc3.KeySet
+		iv     []byte
+		expect map[string]cbc3.Severity
+	}{
+		{"good", &cbc3.KeySet{K1: hexKey("0123456789abcdef"), K2: hexKey("23456789abcdef01"), K3: hexKey("456789abcdef0123")}, iv,
+			map[string]cbc3.Severity{}},
+		// Keys differing only in parity bits are the same DES key.
+		{"k1=k2", &cbc3.KeySet{K1: hexKey("0123456789abcdef"), K2: hexKey("0022446688aaccee"), K3: hexKey("456789abcdef0123")}, iv,
+			map[string]cbc3.Severity{"equal-k1-k2": cbc3.Critical}},
+		{"all equal", &cbc3.KeySet{K1: hexKey("0123456789abcdef"), K2: hexKey("0123456789abcdef"), K3: hexKey("0123456789abcdef")}, iv,
+			map[string]cbc3.Severity{"equal-keys": cbc3.Critical}},
+		{"ssh-1", cbc3.SSH1KeySet([]byte("testit")), nil,
+			map[string]cbc3.Severity{"equal-k1-k3": cbc3.Info, "zero-iv": cbc3.Warning, "repeated-iv": cbc3.Info}},
+		{"weak", &cbc3.KeySet{K1: hexKey("0101010101010101"), K2: hexKey("23456789abcdef01"), K3: hexKey("1fe01fe00ef10ef1")}, iv,
+			map[string]cbc3.Severity{"des-weak-key": cbc3.Critical, "des-semi-weak-key": cbc3.Critical}},
+	}
+	for _, tc := range tests {
+		r, err := cbc3.Analyze(tc.ks, des.NewCipher, tc.iv)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		got := codes(r)
+		if len(got) != len(tc.expect) {
+			t.Errorf("%s: got findings %v, want %v", tc.name, r.Findings, tc.expect)
+		}
+		for code, sev := range tc.expect {
+			if got[code] != sev {
+				t.Errorf("%s: %s severity %v, want %v", tc.name, code, got[code], sev)
+			}
+		}
+		if (cbc3.Validate(tc.ks, des.NewCipher, tc.iv) == nil) != (r.Max() < cbc3.Warning) {
+			t.Errorf("%s: Validate disagrees with the report", tc.name)
+		}
+	}
+}
+
+func TestAnalyzeWeakKeysOnlyForDES(t *testing.T) {
+	// Blowfish has a 64 bit block but no DES weak keys.
+	ks := &cbc3.KeySet{K1: hexKey("0101010101010101"), K2: hexKey("23456789abcdef01"), K3: hexKey("1fe01fe00ef10ef1")}
+	iv := []byte("0123456789abcdefghijklmn")
+	newBlowfish := func(key []byte) (cipher.Block, error) { return blowfish.NewCipher(key) }
+	r, err := cbc3.Analyze(ks, newBlowfish, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 {
+		t.Errorf("Blowfish got findings %v", r.Findings)
+	}
+
+	// Triple DES stages are still checked.
+	ks = &cbc3.KeySet{
+		K1: hexKey("0123456789abcdef010101010101010189abcdef01234567"),
+		K2: hexKey("23456789abcdef01456789abcdef0123fedcba9876543210"),
+		K3: hexKey("456789abcdef012389abcdef01234567f0e1d2c3b4a59687"),
+	}
+	if r, _ = cbc3.Analyze(ks, des.NewTripleDESCipher, iv); codes(r)["des-weak-key"] != cbc3.Critical {
+		t.Errorf("weak key in a triple DES stage not reported: %v", r.Findings)
+	}
+}
+
+func TestStrictConstructors(t *testing.T) {
+	size := cbc3.KeySetSize{KeySize: 16, BlockSize: 16}
+	ks, _ := cbc3.DeriveKeySetHKDF([]byte("secret"), nil, nil, size)
+	if _, err := ks.NewStrictEncrypter(aes.NewCipher, nil); err != nil {
+		t.Errorf("derived key set was refused: %s", err)
+	}
+	if _, err := ks.NewStrictDecrypter(aes.NewCipher, make([]byte, 48)); err == nil {
+		t.Errorf("zero IV was accepted")
+	}
+	ks.K2 = ks.K1
+	if _, err := ks.NewStrictEncrypter(aes.NewCipher, nil); err == nil {
+		t.Errorf("K1 == K2 was accepted")
+	}
+}
+
+func TestEqualK1K2Collapses(t *testing.T) {
+	// Back up the analysis: with K1 == K2 and equal first IV registers the
+	// output is plain CBC under K3.
+	k1, _ := des.NewCipher(hexKey("0123456789abcdef"))
+	k3, _ := des.NewCipher(hexKey("456789abcdef0123"))
+	iv := []byte("AAAAAAAAAAAAAAAAzyxwvuts")
+	plaintext := []byte("exampleplaintextexampleplaintext")
+
+	got := make([]byte, len(plaintext))
+	cbc3.NewEncrypter(k1, k1, k3, iv).CryptBlocks(got, plaintext)
+	want := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(k3, iv[16:]).CryptBlocks(want, plaintext)
+	if string(got) != string(want) {
+		t.Errorf("CBC3 with K1 == K2 did not collapse to single CBC")
+	}
+}
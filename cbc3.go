@@ -26,6 +26,7 @@ package cbc3 // import "github.com/pschou/go-cbc3"
 
 import (
 	"crypto/cipher"
+	"fmt"
 	"unsafe"
 )
 
@@ -34,6 +35,11 @@ type cbc struct {
 	blockSize  int
 	iv         []byte
 	tmp        []byte
+
+	tracer Tracer
+	traced uint64 // blocks passed to tracer
+
+	locked *LockedBuffer // holds iv and tmp after LockMemory
 }
 
 func newCBC3(b1, b2, b3 cipher.Block, iv []byte) *cbc {
@@ -47,12 +53,59 @@ func newCBC3(b1, b2, b3 cipher.Block, iv []byte) *cbc {
 	}
 }
 
-type cbc3Encrypter cbc
+// Direction tells whether a mode encrypts or decrypts.
+type Direction int
+
+// The directions of a Mode.
+const (
+	Encrypting Direction = iota + 1
+	Decrypting
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Encrypting:
+		return "encrypt"
+	case Decrypting:
+		return "decrypt"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// Mode is the BlockMode of NewEncrypter and NewDecrypter along with access to
+// its state, so that code built on this package can manage modes without
+// knowing their direction.
+type Mode interface {
+	cipher.BlockMode
+
+	// SetIV replaces the triple IV registers, which continue from block
+	// to block, with iv.
+	SetIV(iv []byte)
+
+	// IV returns a copy of the current triple IV registers, which after
+	// CryptBlocks are the IV to continue the chain from.
+	IV() []byte
+
+	// Blocks returns the three stage ciphers.
+	Blocks() (b1, b2, b3 cipher.Block)
+
+	// Direction returns Encrypting or Decrypting.
+	Direction() Direction
+
+	// Rekey replaces the stage ciphers, which must have the mode's block
+	// size, for the blocks that follow.  The IV registers carry on unless
+	// iv is non-nil, in which case they are reset to it.
+	Rekey(b1, b2, b3 cipher.Block, iv []byte)
+}
+
+// Encrypter is the CBC3 encrypter returned by NewEncrypter.
+type Encrypter cbc
 
 // NewEncrypter returns a BlockMode which encrypts in cipher block chaining
 // mode, using the given three Blocks, all of which must have the same block
 // size. The length of iv must be the same as the three times the Block's block
 // size.  It is recommended that the blocks be initialized with different IVs.
+// The BlockMode is an *Encrypter.
 func NewEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
 	bs := b1.BlockSize()
 	if bs != b2.BlockSize() || bs != b3.BlockSize() {
@@ -61,12 +114,12 @@ func NewEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
 	if len(iv) != 3*bs {
 		panic("cbc3.NewEncrypter: IV length must equal three times the cipher block size")
 	}
-	return (*cbc3Encrypter)(newCBC3(b1, b2, b3, iv))
+	return (*Encrypter)(newCBC3(b1, b2, b3, iv))
 }
 
-func (x *cbc3Encrypter) BlockSize() int { return x.blockSize }
+func (x *Encrypter) BlockSize() int { return x.blockSize }
 
-func (x *cbc3Encrypter) CryptBlocks(dst, src []byte) {
+func (x *Encrypter) CryptBlocks(dst, src []byte) {
 	// Check input for sane values
 	if len(src)%x.blockSize != 0 {
 		panic("crypto/cipher: input not full blocks")
@@ -77,6 +130,13 @@ func (x *cbc3Encrypter) CryptBlocks(dst, src []byte) {
 	if inexactOverlap(dst[:len(src)], src) {
 		panic("crypto/cipher: invalid buffer overlap")
 	}
+	if len(src) == 0 {
+		return
+	}
+	if x.tracer != nil {
+		(*cbc)(x).cryptBlocksTraced(dst, src, false)
+		return
+	}
 
 	iv0 := x.iv[:x.blockSize]
 	iv1 := x.iv[x.blockSize : 2*x.blockSize]
@@ -103,19 +163,24 @@ func (x *cbc3Encrypter) CryptBlocks(dst, src []byte) {
 	}
 }
 
-func (x *cbc3Encrypter) SetIV(iv []byte) {
-	if len(iv) != len(x.iv) {
-		panic("cipher: incorrect length IV")
-	}
-	copy(x.iv, iv)
-}
+func (x *Encrypter) SetIV(iv []byte) { (*cbc)(x).setIV(iv) }
 
-type cbc3Decrypter cbc
+func (x *Encrypter) IV() []byte { return dup(x.iv) }
+
+func (x *Encrypter) Blocks() (b1, b2, b3 cipher.Block) { return x.b1, x.b2, x.b3 }
+
+func (x *Encrypter) Direction() Direction { return Encrypting }
+
+func (x *Encrypter) Rekey(b1, b2, b3 cipher.Block, iv []byte) { (*cbc)(x).rekey(b1, b2, b3, iv) }
+
+// Decrypter is the CBC3 decrypter returned by NewDecrypter.
+type Decrypter cbc
 
 // NewDecrypter returns a BlockMode which decrypts in cipher block chaining
 // mode, using the given three Blocks, all of which must have the same block
 // size. The length of iv must be the same as the three times the Block's block
-// size and must match the iv used to encrypt the data.
+// size and must match the iv used to encrypt the data.  The BlockMode is a
+// *Decrypter.
 func NewDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
 	bs := b1.BlockSize()
 	if bs != b2.BlockSize() || bs != b3.BlockSize() {
@@ -124,12 +189,12 @@ func NewDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
 	if len(iv) != 3*bs {
 		panic("cbc3.NewDecrypter: IV length must equal three times the cipher block size")
 	}
-	return (*cbc3Decrypter)(newCBC3(b1, b2, b3, iv))
+	return (*Decrypter)(newCBC3(b1, b2, b3, iv))
 }
 
-func (x *cbc3Decrypter) BlockSize() int { return x.blockSize }
+func (x *Decrypter) BlockSize() int { return x.blockSize }
 
-func (x *cbc3Decrypter) CryptBlocks(dst, src []byte) {
+func (x *Decrypter) CryptBlocks(dst, src []byte) {
 	if len(src)%x.blockSize != 0 {
 		panic("crypto/cipher: input not full blocks")
 	}
@@ -142,6 +207,10 @@ func (x *cbc3Decrypter) CryptBlocks(dst, src []byte) {
 	if len(src) == 0 {
 		return
 	}
+	if x.tracer != nil {
+		(*cbc)(x).cryptBlocksTraced(dst, src, true)
+		return
+	}
 
 	iv0 := x.iv[:x.blockSize]
 	iv1 := x.iv[x.blockSize : 2*x.blockSize]
@@ -168,13 +237,33 @@ func (x *cbc3Decrypter) CryptBlocks(dst, src []byte) {
 	}
 }
 
-func (x *cbc3Decrypter) SetIV(iv []byte) {
+func (x *Decrypter) SetIV(iv []byte) { (*cbc)(x).setIV(iv) }
+
+func (x *Decrypter) IV() []byte { return dup(x.iv) }
+
+func (x *Decrypter) Blocks() (b1, b2, b3 cipher.Block) { return x.b1, x.b2, x.b3 }
+
+func (x *Decrypter) Direction() Direction { return Decrypting }
+
+func (x *Decrypter) Rekey(b1, b2, b3 cipher.Block, iv []byte) { (*cbc)(x).rekey(b1, b2, b3, iv) }
+
+func (x *cbc) setIV(iv []byte) {
 	if len(iv) != len(x.iv) {
 		panic("cipher: incorrect length IV")
 	}
 	copy(x.iv, iv)
 }
 
+func (x *cbc) rekey(b1, b2, b3 cipher.Block, iv []byte) {
+	if b1.BlockSize() != x.blockSize || b2.BlockSize() != x.blockSize || b3.BlockSize() != x.blockSize {
+		panic("cbc3.Rekey: BlockSize must equal the mode's block size for all three block ciphers")
+	}
+	if iv != nil {
+		x.setIV(iv)
+	}
+	x.b1, x.b2, x.b3 = b1, b2, b3
+}
+
 func dup(p []byte) []byte {
 	q := make([]byte, len(p))
 	copy(q, p)
@@ -2,6 +2,7 @@ package cbc3_test
 
 import (
 	"bytes"
+	"crypto/aes"
 	"crypto/des"
 	"crypto/md5"
 	"encoding/base64"
@@ -90,6 +91,104 @@ func TestSSH1Decrypt(t *testing.T) {
 	}
 }
 
+func TestModeState(t *testing.T) {
+	b1, _ := des.NewCipher([]byte("key one."))
+	b2, _ := des.NewCipher([]byte("key two."))
+	b3, _ := des.NewCipher([]byte("keythree"))
+	iv := []byte("the twenty-four byte iv.")
+	msg := []byte("sixteen byte msgsixteen byte msg")
+
+	enc := cbc3.NewEncrypter(b1, b2, b3, iv).(cbc3.Mode)
+	dec := cbc3.NewDecrypter(b1, b2, b3, iv).(cbc3.Mode)
+	if enc.Direction() != cbc3.Encrypting || dec.Direction() != cbc3.Decrypting {
+		t.Errorf("directions %v and %v", enc.Direction(), dec.Direction())
+	}
+	if g1, g2, g3 := enc.Blocks(); g1 != b1 || g2 != b2 || g3 != b3 {
+		t.Errorf("Blocks returned other ciphers")
+	}
+	if !bytes.Equal(enc.IV(), iv) {
+		t.Errorf("IV %x, want %x", enc.IV(), iv)
+	}
+
+	// Encrypting in two calls continues from the IV left by the first, and
+	// a fresh mode set to that IV produces the same second half.
+	ct := make([]byte, len(msg))
+	enc.CryptBlocks(ct[:16], msg[:16])
+	mid := enc.IV()
+	mid[0] ^= 1
+	if bytes.Equal(enc.IV(), mid) {
+		t.Errorf("IV returned the registers themselves")
+	}
+	mid[0] ^= 1
+	enc.CryptBlocks(ct[16:], msg[16:])
+	again := make([]byte, len(msg))
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(again, msg)
+	if !bytes.Equal(ct, again) {
+		t.Errorf("split encryption differs")
+	}
+	enc.SetIV(mid)
+	enc.CryptBlocks(again[16:], msg[16:])
+	if !bytes.Equal(ct, again) {
+		t.Errorf("encryption from IV() differs")
+	}
+
+	pt := make([]byte, len(ct))
+	dec.CryptBlocks(pt, ct)
+	if !bytes.Equal(pt, msg) || !bytes.Equal(dec.IV(), enc.IV()) {
+		t.Errorf("decrypter state differs from the encrypter")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	b1, _ := des.NewCipher([]byte("key one."))
+	b2, _ := des.NewCipher([]byte("key two."))
+	b3, _ := des.NewCipher([]byte("keythree"))
+	n1, _ := des.NewCipher([]byte("new one."))
+	n2, _ := des.NewCipher([]byte("new two."))
+	n3, _ := des.NewCipher([]byte("newthree"))
+	iv := []byte("the twenty-four byte iv.")
+	msg := []byte("sixteen byte msgsixteen byte msg")
+
+	enc := cbc3.NewEncrypter(b1, b2, b3, iv).(cbc3.Mode)
+	ct := make([]byte, len(msg))
+	enc.CryptBlocks(ct[:16], msg[:16])
+	mid := enc.IV()
+	enc.Rekey(n1, n2, n3, nil)
+	enc.CryptBlocks(ct[16:], msg[16:])
+
+	// The second half is the new keys continuing from the old chain.
+	want := make([]byte, 16)
+	cbc3.NewEncrypter(n1, n2, n3, mid).CryptBlocks(want, msg[16:])
+	if !bytes.Equal(ct[16:], want) {
+		t.Errorf("rekeyed encryption did not continue the chain")
+	}
+
+	dec := cbc3.NewDecrypter(b1, b2, b3, iv).(cbc3.Mode)
+	pt := make([]byte, len(ct))
+	dec.CryptBlocks(pt[:16], ct[:16])
+	dec.Rekey(n1, n2, n3, nil)
+	dec.CryptBlocks(pt[16:], ct[16:])
+	if !bytes.Equal(pt, msg) {
+		t.Errorf("rekeyed decryption failed")
+	}
+
+	enc.Rekey(b1, b2, b3, iv)
+	again := make([]byte, len(msg))
+	enc.CryptBlocks(again, msg)
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, msg[:16])
+	if !bytes.Equal(again[:16], want) {
+		t.Errorf("rekey with an IV did not reset the chain")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("rekey with a different block size did not panic")
+		}
+	}()
+	a, _ := aes.NewCipher(make([]byte, 16))
+	enc.Rekey(a, a, a, nil)
+}
+
 func b64decode(str string) []byte {
 	noWhiteSpace := strings.NewReplacer("\r", "", "\n", "", "\t", "", " ", "")
 	dat, _ := base64.StdEncoding.DecodeString(noWhiteSpace.Replace(str))
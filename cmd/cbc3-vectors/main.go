@@ -0,0 +1,147 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command cbc3-vectors writes CBC3 known answer vectors as JSON, computed with
+// the standard library reference implementation.
+//
+// Without flags it writes the corpus kept in testdata/vectors.json:
+//
+//	go run ./cmd/cbc3-vectors > testdata/vectors.json
+//
+// A single vector can be made for checking another implementation:
+//
+//	cbc3-vectors -alg des,des,des -key 0123456789abcdef,23456789abcdef01,456789abcdef0123 \
+//		-iv <48 hex digits> -pt <hex>
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+// corpus lists the stage algorithms of the generated corpus.
+var corpus = [][3]string{
+	{"des", "des", "des"},
+	{"aes-128", "aes-128", "aes-128"},
+	{"aes-192", "aes-192", "aes-192"},
+	{"aes-256", "aes-256", "aes-256"},
+	{"blowfish", "blowfish", "blowfish"},
+	{"cast5", "cast5", "cast5"},
+	{"des", "blowfish", "cast5"},
+	{"cast5", "des", "blowfish"},
+	{"aes-128", "aes-256", "aes-192"},
+}
+
+// corpusBlocks are the message lengths, in blocks, made for each entry.
+var corpusBlocks = []int{1, 2, 5}
+
+func main() {
+	var (
+		alg = flag.String("alg", "", "comma separated stage algorithms, one of "+strings.Join(cbc3.Algorithms(), ", "))
+		key = flag.String("key", "", "comma separated hex stage keys")
+		iv  = flag.String("iv", "", "hex triple IV")
+		pt  = flag.String("pt", "", "hex plaintext, full blocks")
+	)
+	flag.Parse()
+
+	var vs []*cbc3.Vector
+	var err error
+	if *alg == "" {
+		vs, err = generateCorpus()
+	} else {
+		var v *cbc3.Vector
+		v, err = single(*alg, *key, *iv, *pt)
+		vs = append(vs, v)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cbc3-vectors:", err)
+		os.Exit(1)
+	}
+	out, _ := json.MarshalIndent(vs, "", "  ")
+	os.Stdout.Write(append(out, '\n'))
+}
+
+// fill returns n bytes of SHA-256 in counter mode over label, so that the
+// corpus is the same every time it is generated.
+func fill(label string, n int) []byte {
+	var out []byte
+	for i := uint32(0); len(out) < n; i++ {
+		h := sha256.New()
+		var ctr [4]byte
+		binary.BigEndian.PutUint32(ctr[:], i)
+		h.Write(ctr[:])
+		h.Write([]byte(label))
+		out = h.Sum(out)
+	}
+	return out[:n]
+}
+
+func generateCorpus() ([]*cbc3.Vector, error) {
+	var vs []*cbc3.Vector
+	for _, algs := range corpus {
+		var keys [3][]byte
+		var bs int
+		for i, name := range algs {
+			a, ok := cbc3.LookupAlgorithm(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown algorithm %q", name)
+			}
+			keys[i] = fill(fmt.Sprintf("%s key %d", strings.Join(algs[:], "|"), i+1), a.KeySize)
+			bs = a.BlockSize
+		}
+		for _, n := range corpusBlocks {
+			name := fmt.Sprintf("%s/%d", strings.Join(algs[:], "|"), n)
+			v, err := cbc3.NewVector(name, algs, keys, fill(name+" iv", 3*bs), fill(name+" plaintext", n*bs))
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+		}
+	}
+	return vs, nil
+}
+
+func single(alg, key, iv, pt string) (*cbc3.Vector, error) {
+	algs, keys := strings.Split(alg, ","), strings.Split(key, ",")
+	if len(algs) != 3 || len(keys) != 3 {
+		return nil, fmt.Errorf("-alg and -key need three comma separated values")
+	}
+	var a [3]string
+	var k [3][]byte
+	for i := range algs {
+		a[i] = algs[i]
+		b, err := hex.DecodeString(keys[i])
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i+1, err)
+		}
+		k[i] = b
+	}
+	ivb, err := hex.DecodeString(iv)
+	if err != nil {
+		return nil, fmt.Errorf("iv: %v", err)
+	}
+	ptb, err := hex.DecodeString(pt)
+	if err != nil {
+		return nil, fmt.Errorf("pt: %v", err)
+	}
+	return cbc3.NewVector(alg, a, k, ivb, ptb)
+}
@@ -0,0 +1,498 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command cbc3 encrypts and decrypts files with CBC3.
+//
+//	cbc3 enc [flags] [input [output]]
+//	cbc3 dec [flags] [input [output]]
+//
+// Input and output default to stdin and stdout, "-" naming them explicitly.
+// An output file is replaced only once the run succeeds, so it may name the
+// input.
+//
+// The default format is the container of NewContainerWriter, which records
+// the suite, padding, KDF parameters and IV in an authenticated header, so
+// decrypting needs only the key or passphrase.  The key is given with -key as
+// hex, a 32 byte MAC key followed by the three stage keys, or derived from a
+// passphrase read from -pass-file or the environment variable named by
+// -pass-env.
+//
+// With -format raw the output is bare CBC3 as written by NewWriter, or with
+// -mac the segmented stream of NewSegmentWriter.  Nothing about the
+// encryption is recorded, so the same flags must be given to decrypt.  -alg
+// may name three different stage algorithms, and -key holds the stage keys
+// back to back or comma separated.  A passphrase needs a -salt.  The IV is
+// random and written in front of the ciphertext unless -iv gives it in hex,
+// or as "derived" to take it from the passphrase KDF.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "cbc3:", err)
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	decrypt  bool
+	format   string
+	alg      string
+	key      string
+	passFile string
+	passEnv  string
+	kdf      string
+	iter     uint
+	mem      uint
+	par      uint
+	salt     string
+	iv       string
+	padding  string
+	mac      bool
+	macKey   string
+	keyID    string
+	segment  int
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 || (args[0] != "enc" && args[0] != "dec") {
+		fmt.Fprintln(stderr, "usage: cbc3 enc|dec [flags] [input [output]]")
+		return errors.New("missing enc or dec")
+	}
+	o := &options{decrypt: args[0] == "dec"}
+	fs := flag.NewFlagSet("cbc3 "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.format, "format", "container", "output format, container or raw")
+	fs.StringVar(&o.alg, "alg", "aes-256", "stage algorithm, or three comma separated for raw: "+strings.Join(cbc3.Algorithms(), ", "))
+	fs.StringVar(&o.key, "key", "", "hex key material")
+	fs.StringVar(&o.passFile, "pass-file", "", "read the passphrase from this file")
+	fs.StringVar(&o.passEnv, "pass-env", "", "read the passphrase from this environment variable")
+	fs.StringVar(&o.kdf, "kdf", "argon2id", "passphrase KDF: argon2id, scrypt or pbkdf2")
+	fs.UintVar(&o.iter, "iter", 0, "KDF iterations, time or cost N (0 for the default)")
+	fs.UintVar(&o.mem, "mem", 0, "KDF memory in KiB, or scrypt r (0 for the default)")
+	fs.UintVar(&o.par, "par", 0, "KDF parallelism (0 for the default)")
+	fs.StringVar(&o.salt, "salt", "", "hex KDF salt, raw format only")
+	fs.StringVar(&o.iv, "iv", "", "hex triple IV, or \"derived\" in raw format; random when empty")
+	fs.StringVar(&o.padding, "padding", "pkcs7", "padding: pkcs7, ansix923, iso7816, zero or none")
+	fs.BoolVar(&o.mac, "mac", false, "authenticate the raw format with the segmented stream")
+	fs.StringVar(&o.macKey, "mac-key", "", "hex MAC key for -mac with -key")
+	fs.StringVar(&o.keyID, "key-id", "", "key identifier stored in the container header")
+	fs.IntVar(&o.segment, "segment", cbc3.DefaultSegmentSize, "segment size in bytes")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 2 {
+		return errors.New("too many arguments")
+	}
+
+	in := stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	// An output file is written beside its target and renamed over it only
+	// on success, so that naming the input as output does not truncate it
+	// before it is read, and a failure leaves any existing file untouched.
+	var outFile *os.File
+	out := stdout
+	outName := fs.Arg(1)
+	if outName != "" && outName != "-" {
+		f, err := ioutil.TempFile(filepath.Dir(outName), "."+filepath.Base(outName)+".tmp")
+		if err != nil {
+			return err
+		}
+		outFile, out = f, f
+	}
+	bw := bufio.NewWriter(out)
+
+	var err error
+	switch o.format {
+	case "container":
+		err = o.container(bufio.NewReader(in), bw)
+	case "raw":
+		err = o.raw(bufio.NewReader(in), bw)
+	default:
+		err = fmt.Errorf("unknown format %q", o.format)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if outFile != nil {
+		if cerr := outFile.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(outFile.Name(), outName)
+		}
+		if err != nil {
+			// Do not leave partial or unauthenticated output behind.
+			os.Remove(outFile.Name())
+		}
+	}
+	return err
+}
+
+func (o *options) passphrase() ([]byte, error) {
+	switch {
+	case o.passFile != "" && o.passEnv != "":
+		return nil, errors.New("give only one of -pass-file and -pass-env")
+	case o.passFile != "":
+		b, err := ioutil.ReadFile(o.passFile)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	case o.passEnv != "":
+		p, ok := os.LookupEnv(o.passEnv)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", o.passEnv)
+		}
+		return []byte(p), nil
+	}
+	return nil, nil
+}
+
+// kdfParams fills in the chosen KDF and its default parameters.
+func (o *options) kdfParams(salt []byte) (cbc3.KDFParams, error) {
+	if o.iter > math.MaxUint32 || o.mem > math.MaxUint32 || o.par > math.MaxUint8 {
+		return cbc3.KDFParams{}, errors.New("KDF parameter out of range")
+	}
+	p := cbc3.KDFParams{Salt: salt, Iterations: uint32(o.iter), Memory: uint32(o.mem), Parallelism: uint8(o.par)}
+	def := func(v *uint32, d uint32) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	switch o.kdf {
+	case "argon2id":
+		p.KDF = cbc3.KDFArgon2id
+		def(&p.Iterations, 3)
+		def(&p.Memory, 64<<10)
+		if p.Parallelism == 0 {
+			p.Parallelism = 4
+		}
+	case "scrypt":
+		p.KDF = cbc3.KDFScrypt
+		def(&p.Iterations, 1<<15)
+		def(&p.Memory, 8)
+		if p.Parallelism == 0 {
+			p.Parallelism = 1
+		}
+	case "pbkdf2":
+		p.KDF = cbc3.KDFPBKDF2
+		def(&p.Iterations, 600000)
+	default:
+		return p, fmt.Errorf("unknown KDF %q", o.kdf)
+	}
+	return p, nil
+}
+
+func decodeHex(name, s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b, nil
+}
+
+var suites = map[string]cbc3.Suite{
+	"des":     cbc3.SuiteDES,
+	"aes-128": cbc3.SuiteAES128,
+	"aes-192": cbc3.SuiteAES192,
+	"aes-256": cbc3.SuiteAES256,
+}
+
+var paddingSchemes = map[string]cbc3.PaddingScheme{
+	"pkcs7":    cbc3.PaddingPKCS7,
+	"ansix923": cbc3.PaddingANSIX923,
+	"iso7816":  cbc3.PaddingISO7816,
+}
+
+func (o *options) container(in io.Reader, out io.Writer) error {
+	pass, err := o.passphrase()
+	if err != nil {
+		return err
+	}
+	if (pass == nil) == (o.key == "") {
+		return errors.New("give either -key or a passphrase")
+	}
+	var key []byte
+	if o.key != "" {
+		if key, err = decodeHex("key", o.key); err != nil {
+			return err
+		}
+	}
+
+	if o.decrypt {
+		r, _, err := cbc3.NewContainerReader(in, func(h *cbc3.Header) ([]byte, error) {
+			if key != nil {
+				return key, nil
+			}
+			return h.KDF.ContainerKey(pass, h.Suite)
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, r)
+		return err
+	}
+
+	suite, ok := suites[o.alg]
+	if !ok {
+		return fmt.Errorf("the container supports only des, aes-128, aes-192 and aes-256, not %q", o.alg)
+	}
+	scheme, ok := paddingSchemes[o.padding]
+	if !ok {
+		return fmt.Errorf("the container supports only pkcs7, ansix923 and iso7816 padding, not %q", o.padding)
+	}
+	h := &cbc3.Header{Suite: suite, Padding: scheme, KeyID: []byte(o.keyID), SegmentSize: uint32(o.segment)}
+	if o.iv != "" {
+		if h.IV, err = decodeHex("iv", o.iv); err != nil {
+			return err
+		}
+	}
+	if key == nil {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		if h.KDF, err = o.kdfParams(salt); err != nil {
+			return err
+		}
+		if key, err = h.KDF.ContainerKey(pass, suite); err != nil {
+			return err
+		}
+	}
+	w, err := cbc3.NewContainerWriter(out, h, key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+var paddings = map[string]cbc3.Padding{
+	"pkcs7":    cbc3.PKCS7Padding,
+	"ansix923": cbc3.ANSIX923Padding,
+	"iso7816":  cbc3.ISO7816Padding,
+	"zero":     cbc3.ZeroPadding,
+	"none":     cbc3.NoPadding,
+}
+
+func (o *options) raw(in io.Reader, out io.Writer) error {
+	names := strings.Split(o.alg, ",")
+	if len(names) == 1 {
+		names = []string{names[0], names[0], names[0]}
+	}
+	if len(names) != 3 {
+		return errors.New("-alg needs one or three algorithms")
+	}
+	var algs [3]cbc3.Algorithm
+	maxKey := 0
+	for i, n := range names {
+		a, ok := cbc3.LookupAlgorithm(n)
+		if !ok {
+			return fmt.Errorf("unknown algorithm %q", n)
+		}
+		if i > 0 && a.BlockSize != algs[0].BlockSize {
+			return errors.New("the stage algorithms must have the same block size")
+		}
+		if a.KeySize > maxKey {
+			maxKey = a.KeySize
+		}
+		algs[i] = a
+	}
+	bs := algs[0].BlockSize
+	padding, ok := paddings[o.padding]
+	if !ok {
+		return fmt.Errorf("unknown padding %q", o.padding)
+	}
+
+	pass, err := o.passphrase()
+	if err != nil {
+		return err
+	}
+	var keys [3][]byte
+	var ks *cbc3.KeySet
+	switch {
+	case (pass == nil) == (o.key == ""):
+		return errors.New("give either -key or a passphrase")
+	case o.key != "":
+		if keys, err = splitKeys(o.key, algs); err != nil {
+			return err
+		}
+	default:
+		if o.salt == "" {
+			return errors.New("a passphrase needs -salt in the raw format")
+		}
+		salt, err := decodeHex("salt", o.salt)
+		if err != nil {
+			return err
+		}
+		p, err := o.kdfParams(salt)
+		if err != nil {
+			return err
+		}
+		// Keys are derived at the longest stage key size and cut to each.
+		size := cbc3.KeySetSize{KeySize: maxKey, BlockSize: bs, MACSize: 32}
+		switch p.KDF {
+		case cbc3.KDFArgon2id:
+			ks, err = cbc3.DeriveKeySetArgon2id(pass, salt, p.Iterations, p.Memory, p.Parallelism, size)
+		case cbc3.KDFScrypt:
+			ks, err = cbc3.DeriveKeySetScrypt(pass, salt, int(p.Iterations), int(p.Memory), int(p.Parallelism), size)
+		case cbc3.KDFPBKDF2:
+			ks, err = cbc3.DeriveKeySetPBKDF2(pass, salt, int(p.Iterations), size)
+		}
+		if err != nil {
+			return err
+		}
+		for i, k := range [][]byte{ks.K1, ks.K2, ks.K3} {
+			keys[i] = k[:algs[i].KeySize]
+		}
+	}
+	b1, err := algs[0].New(keys[0])
+	if err != nil {
+		return err
+	}
+	b2, err := algs[1].New(keys[1])
+	if err != nil {
+		return err
+	}
+	b3, err := algs[2].New(keys[2])
+	if err != nil {
+		return err
+	}
+
+	if o.mac {
+		if o.iv != "" || o.padding != "pkcs7" {
+			return errors.New("-mac writes its own random IV and uses pkcs7 padding")
+		}
+		var macKey []byte
+		switch {
+		case o.macKey != "":
+			if macKey, err = decodeHex("mac-key", o.macKey); err != nil {
+				return err
+			}
+		case ks != nil:
+			macKey = ks.MACKey
+		default:
+			return errors.New("-mac with -key needs -mac-key")
+		}
+		if o.decrypt {
+			r, err := cbc3.NewSegmentReader(in, b1, b2, b3, macKey, o.segment)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, r)
+			return err
+		}
+		w, err := cbc3.NewSegmentWriter(out, b1, b2, b3, macKey, o.segment)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	var iv []byte
+	switch o.iv {
+	case "derived":
+		if ks == nil {
+			return errors.New("-iv derived needs a passphrase")
+		}
+		iv = ks.IV
+	case "":
+		iv = make([]byte, 3*bs)
+		if o.decrypt {
+			if _, err := io.ReadFull(in, iv); err != nil {
+				return fmt.Errorf("reading the IV: %v", err)
+			}
+		} else {
+			if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+				return err
+			}
+			if _, err := out.Write(iv); err != nil {
+				return err
+			}
+		}
+	default:
+		if iv, err = decodeHex("iv", o.iv); err != nil {
+			return err
+		}
+		if len(iv) != 3*bs {
+			return fmt.Errorf("-iv must be %d bytes", 3*bs)
+		}
+	}
+
+	if o.decrypt {
+		_, err = io.Copy(out, cbc3.NewReader(in, cbc3.NewDecrypter(b1, b2, b3, iv), padding))
+		return err
+	}
+	w := cbc3.NewWriter(out, cbc3.NewEncrypter(b1, b2, b3, iv), padding)
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// splitKeys decodes the stage keys, given comma separated or back to back.
+func splitKeys(s string, algs [3]cbc3.Algorithm) (keys [3][]byte, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) == 3 {
+		for i, p := range parts {
+			if keys[i], err = decodeHex("key", p); err != nil {
+				return
+			}
+		}
+		return
+	}
+	if len(parts) != 1 {
+		return keys, errors.New("-key needs one or three hex strings")
+	}
+	b, err := decodeHex("key", s)
+	if err != nil {
+		return keys, err
+	}
+	if len(b) != algs[0].KeySize+algs[1].KeySize+algs[2].KeySize {
+		return keys, fmt.Errorf("-key must be %d bytes", algs[0].KeySize+algs[1].KeySize+algs[2].KeySize)
+	}
+	for i := range keys {
+		keys[i], b = b[:algs[i].KeySize], b[algs[i].KeySize:]
+	}
+	return keys, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/des"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+var plaintext = []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 50))
+
+func cli(t *testing.T, in []byte, args ...string) ([]byte, error) {
+	var out, errOut bytes.Buffer
+	err := run(args, bytes.NewReader(in), &out, &errOut)
+	return out.Bytes(), err
+}
+
+func TestContainerKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32+3*16)
+	hexKey := hex.EncodeToString(key)
+	ct, err := cli(t, plaintext, "enc", "-alg", "aes-128", "-key", hexKey, "-segment", "256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The library reads what the command writes.
+	r, h, err := cbc3.NewContainerReader(bytes.NewReader(ct), func(*cbc3.Header) ([]byte, error) { return key, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(got, plaintext) || h.Suite != cbc3.SuiteAES128 {
+		t.Fatalf("library decryption failed: %v", err)
+	}
+
+	if got, err = cli(t, ct, "dec", "-key", hexKey); err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("command decryption failed: %v", err)
+	}
+	ct[len(ct)-1] ^= 1
+	if _, err = cli(t, ct, "dec", "-key", hexKey); err == nil {
+		t.Errorf("tampered container was accepted")
+	}
+}
+
+func TestContainerPassphrase(t *testing.T) {
+	os.Setenv("CBC3_TEST_PASS", "correct horse")
+	defer os.Unsetenv("CBC3_TEST_PASS")
+	ct, err := cli(t, plaintext, "enc", "-alg", "des", "-pass-env", "CBC3_TEST_PASS", "-kdf", "pbkdf2", "-iter", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cli(t, ct, "dec", "-pass-env", "CBC3_TEST_PASS")
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("decryption failed: %v", err)
+	}
+}
+
+func TestRawMatchesLibrary(t *testing.T) {
+	k := []byte("0123456789abcdef01234567")
+	iv := bytes.Repeat([]byte{7}, 24)
+	ct, err := cli(t, plaintext, "enc", "-format", "raw", "-alg", "des", "-key", hex.EncodeToString(k), "-iv", hex.EncodeToString(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, _ := des.NewCipher(k[:8])
+	b2, _ := des.NewCipher(k[8:16])
+	b3, _ := des.NewCipher(k[16:])
+	want := cbc3.PKCS7Padding.Pad(append([]byte{}, plaintext...), 8)
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, want)
+	if !bytes.Equal(ct, want) {
+		t.Fatalf("raw output differs from the library")
+	}
+}
+
+func TestRawRoundTrips(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cbc3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pass := filepath.Join(dir, "pass")
+	ioutil.WriteFile(pass, []byte("secret\n"), 0600)
+	mixedKey := hex.EncodeToString(bytes.Repeat([]byte{1}, 8)) + "," + hex.EncodeToString(bytes.Repeat([]byte{2}, 16)) + "," + hex.EncodeToString(bytes.Repeat([]byte{3}, 16))
+
+	for _, args := range [][]string{
+		{"-format", "raw", "-alg", "des,blowfish,cast5", "-key", mixedKey},
+		{"-format", "raw", "-alg", "aes-192", "-pass-file", pass, "-salt", "00112233", "-kdf", "scrypt", "-iter", "1024"},
+		{"-format", "raw", "-alg", "aes-128", "-pass-file", pass, "-salt", "00112233", "-kdf", "pbkdf2", "-iter", "10", "-iv", "derived", "-padding", "iso7816"},
+		{"-format", "raw", "-alg", "des", "-pass-file", pass, "-salt", "00", "-kdf", "pbkdf2", "-iter", "10", "-mac", "-segment", "64"},
+		{"-format", "raw", "-alg", "des", "-key", mixedKey[:16] + mixedKey[:16] + mixedKey[:16], "-mac", "-mac-key", "00ff"},
+	} {
+		in := filepath.Join(dir, "in")
+		mid := filepath.Join(dir, "mid")
+		out := filepath.Join(dir, "out")
+		ioutil.WriteFile(in, plaintext, 0600)
+		if _, err := cli(t, nil, append(append([]string{"enc"}, args...), in, mid)...); err != nil {
+			t.Errorf("%v: enc: %v", args, err)
+			continue
+		}
+		if _, err := cli(t, nil, append(append([]string{"dec"}, args...), mid, out)...); err != nil {
+			t.Errorf("%v: dec: %v", args, err)
+			continue
+		}
+		if got, _ := ioutil.ReadFile(out); !bytes.Equal(got, plaintext) {
+			t.Errorf("%v: round trip failed", args)
+		}
+	}
+}
+
+func TestOutputOverInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cbc3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	args := []string{"-alg", "aes-128", "-key", hex.EncodeToString(bytes.Repeat([]byte{0x42}, 32+3*16))}
+	f := filepath.Join(dir, "file")
+	ioutil.WriteFile(f, plaintext, 0600)
+
+	// Encrypting and decrypting a file onto itself works in place.
+	if _, err := cli(t, nil, append(append([]string{"enc"}, args...), f, f)...); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(f); len(got) == 0 || bytes.Contains(got, plaintext[:16]) {
+		t.Fatalf("file not encrypted in place")
+	}
+	ct, _ := ioutil.ReadFile(f)
+	if _, err := cli(t, nil, append(append([]string{"dec"}, args...), f, f)...); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(f); !bytes.Equal(got, plaintext) {
+		t.Fatalf("file not decrypted in place")
+	}
+
+	// A failed decryption leaves an existing output file and no temporary.
+	ct[len(ct)-1] ^= 1
+	bad := filepath.Join(dir, "bad")
+	ioutil.WriteFile(bad, ct, 0600)
+	if _, err := cli(t, nil, append(append([]string{"dec"}, args...), bad, f)...); err == nil {
+		t.Fatal("tampered input was accepted")
+	}
+	if got, _ := ioutil.ReadFile(f); !bytes.Equal(got, plaintext) {
+		t.Errorf("existing output changed by a failed run")
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, ".*")); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+func TestFlagErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"enc", "-format", "zip", "-key", "00"},
+		{"enc", "-alg", "blowfish", "-key", "00"},
+		{"enc", "-format", "raw", "-alg", "des,aes-128,des", "-key", "00"},
+		{"enc", "-format", "raw", "-alg", "des", "-pass-env", "PATH"},
+		{"dec", "-format", "raw", "-alg", "des", "-key", "0011"},
+	} {
+		if _, err := cli(t, plaintext, args...); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
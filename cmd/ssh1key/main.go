@@ -0,0 +1,323 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command ssh1key inspects, decrypts, re-encrypts and converts SSH-1 private
+// key files.
+//
+//	ssh1key info [input]
+//	ssh1key verify [flags] [input]
+//	ssh1key passwd [flags] [input [output]]
+//	ssh1key strip [flags] [input [output]]
+//	ssh1key convert -to pkcs1|pkcs8|openssh|public [flags] [input [output]]
+//	ssh1key recover -wordlist file [-rules list] [input]
+//
+// Input and output default to stdin and stdout, "-" naming them explicitly.
+// info prints the key size, comment, fingerprint and cipher type without
+// needing the passphrase.  verify exits with an error unless the passphrase
+// opens the key.  passwd writes the key encrypted with CBC3 3DES under the
+// new passphrase, and strip writes it unencrypted.  convert writes the
+// private key as PKCS#1 or PKCS#8 PEM or in the OpenSSH format, or the public
+// key as an authorized_keys line.  recover searches for a lost passphrase
+// among the words of -wordlist and their mutations under the comma separated
+// -rules, printing progress to stderr until interrupted.
+//
+// The passphrase is read from -pass-file or the environment variable named by
+// -pass-env, and the new one for passwd from -new-pass-file or -new-pass-env.
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/pschou/go-cbc3/ssh1"
+	"golang.org/x/crypto/ssh"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "ssh1key:", err)
+		os.Exit(1)
+	}
+}
+
+const usage = "usage: ssh1key info|verify|passwd|strip|convert|recover [flags] [input [output]]"
+
+type options struct {
+	passFile    string
+	passEnv     string
+	newPassFile string
+	newPassEnv  string
+	to          string
+	wordlist    string
+	rules       string
+	workers     int
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return errors.New("missing command")
+	}
+	cmd := args[0]
+	maxArgs := 2
+	switch cmd {
+	case "info", "verify", "recover":
+		maxArgs = 1
+	case "passwd", "strip", "convert":
+	default:
+		fmt.Fprintln(stderr, usage)
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	o := &options{}
+	fs := flag.NewFlagSet("ssh1key "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if cmd != "info" && cmd != "recover" {
+		fs.StringVar(&o.passFile, "pass-file", "", "read the passphrase from this file")
+		fs.StringVar(&o.passEnv, "pass-env", "", "read the passphrase from this environment variable")
+	}
+	if cmd == "passwd" {
+		fs.StringVar(&o.newPassFile, "new-pass-file", "", "read the new passphrase from this file")
+		fs.StringVar(&o.newPassEnv, "new-pass-env", "", "read the new passphrase from this environment variable")
+	}
+	if cmd == "convert" {
+		fs.StringVar(&o.to, "to", "", "output format: pkcs1, pkcs8, openssh or public")
+	}
+	if cmd == "recover" {
+		fs.StringVar(&o.wordlist, "wordlist", "", "file of candidate passphrases, one per line")
+		fs.StringVar(&o.rules, "rules", "", "comma separated mutation rules: "+strings.Join(ruleNames(), ", "))
+		fs.IntVar(&o.workers, "workers", 0, "number of worker goroutines (0 for one per CPU)")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > maxArgs {
+		return errors.New("too many arguments")
+	}
+
+	in := stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	kf, err := ssh1.ParseKeyFile(data)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch cmd {
+	case "info":
+		return info(stdout, kf)
+	case "verify":
+		pass, err := passphrase(o.passFile, o.passEnv, "-pass")
+		if err != nil {
+			return err
+		}
+		if !kf.CheckPassphrase(pass) {
+			return ssh1.ErrPassphrase
+		}
+		if _, err := kf.Decrypt(pass); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "passphrase ok")
+		return nil
+	case "passwd", "strip":
+		out, err = o.passwd(kf, cmd == "passwd")
+	case "convert":
+		out, err = o.convert(kf)
+	case "recover":
+		return o.recover(kf, stdout, stderr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if name := fs.Arg(1); name != "" && name != "-" {
+		// Private key material is written owner readable only.
+		return ioutil.WriteFile(name, out, 0600)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+func info(w io.Writer, kf *ssh1.KeyFile) error {
+	cipher := "none"
+	if kf.CipherType == ssh1.Cipher3DES {
+		cipher = "3des"
+	}
+	pub, err := ssh.NewPublicKey(kf.PublicKey())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "bits: %d\n", kf.Bits)
+	fmt.Fprintf(w, "comment: %s\n", kf.Comment)
+	fmt.Fprintf(w, "fingerprint: %s\n", kf.Fingerprint())
+	fmt.Fprintf(w, "fingerprint: %s\n", ssh.FingerprintSHA256(pub))
+	_, err = fmt.Fprintf(w, "cipher: %s\n", cipher)
+	return err
+}
+
+// passwd re-encrypts the key under the new passphrase, or writes it
+// unencrypted for strip.
+func (o *options) passwd(kf *ssh1.KeyFile, encrypt bool) ([]byte, error) {
+	key, err := o.decrypt(kf)
+	if err != nil {
+		return nil, err
+	}
+	var newPass []byte
+	if encrypt {
+		if newPass, err = passphrase(o.newPassFile, o.newPassEnv, "-new-pass"); err != nil {
+			return nil, err
+		}
+		if len(newPass) == 0 {
+			return nil, errors.New("passwd needs a non-empty -new-pass-file or -new-pass-env, use strip to remove the passphrase")
+		}
+	}
+	return ssh1.Marshal(key, kf.Comment, newPass)
+}
+
+func (o *options) convert(kf *ssh1.KeyFile) ([]byte, error) {
+	if o.to == "public" {
+		pub, err := ssh.NewPublicKey(kf.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pub)), "\n")
+		if kf.Comment != "" {
+			line += " " + kf.Comment
+		}
+		return []byte(line + "\n"), nil
+	}
+
+	key, err := o.decrypt(kf)
+	if err != nil {
+		return nil, err
+	}
+	var block *pem.Block
+	switch o.to {
+	case "pkcs1":
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case "pkcs8":
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case "openssh":
+		if block, err = ssh.MarshalPrivateKey(key, kf.Comment); err != nil {
+			return nil, err
+		}
+	case "":
+		return nil, errors.New("convert needs -to")
+	default:
+		return nil, fmt.Errorf("unknown output format %q", o.to)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func ruleNames() []string {
+	var names []string
+	for name := range ssh1.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (o *options) recover(kf *ssh1.KeyFile, stdout, stderr io.Writer) error {
+	if o.wordlist == "" {
+		return errors.New("recover needs -wordlist")
+	}
+	if !kf.Encrypted() {
+		return errors.New("the key is not encrypted")
+	}
+	opts := &ssh1.RecoverOptions{
+		Workers:  o.workers,
+		Progress: func(n uint64) { fmt.Fprintf(stderr, "tried %d\n", n) },
+	}
+	if o.rules != "" {
+		for _, name := range strings.Split(o.rules, ",") {
+			r, ok := ssh1.Rules[name]
+			if !ok {
+				return fmt.Errorf("unknown rule %q", name)
+			}
+			opts.Rules = append(opts.Rules, r)
+		}
+	}
+	words, err := os.Open(o.wordlist)
+	if err != nil {
+		return err
+	}
+	defer words.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	pass, err := ssh1.Recover(ctx, kf, words, opts)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", pass)
+	return err
+}
+
+func (o *options) decrypt(kf *ssh1.KeyFile) (*rsa.PrivateKey, error) {
+	pass, err := passphrase(o.passFile, o.passEnv, "-pass")
+	if err != nil {
+		return nil, err
+	}
+	if kf.Encrypted() && pass == nil {
+		return nil, errors.New("the key is encrypted, give -pass-file or -pass-env")
+	}
+	return kf.Decrypt(pass)
+}
+
+func passphrase(file, env, flagName string) ([]byte, error) {
+	switch {
+	case file != "" && env != "":
+		return nil, fmt.Errorf("give only one of %s-file and %s-env", flagName, flagName)
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	case env != "":
+		p, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		return []byte(p), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-cbc3/ssh1"
+	"golang.org/x/crypto/ssh"
+)
+
+const identity = "../../ssh1/testdata/identity"
+
+func cli(t *testing.T, in []byte, args ...string) ([]byte, error) {
+	var out, errOut bytes.Buffer
+	err := run(args, bytes.NewReader(in), &out, &errOut)
+	return out.Bytes(), err
+}
+
+func setPass(t *testing.T, name, value string) {
+	os.Setenv(name, value)
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func TestInfo(t *testing.T) {
+	out, err := cli(t, nil, "info", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"bits: 1024\n", "cipher: 3des\n", "fingerprint: SHA256:"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("info output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	setPass(t, "SSH1KEY_TEST_PASS", "testit")
+	if _, err := cli(t, nil, "verify", "-pass-env", "SSH1KEY_TEST_PASS", identity); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+	setPass(t, "SSH1KEY_TEST_WRONG", "wrong")
+	if _, err := cli(t, nil, "verify", "-pass-env", "SSH1KEY_TEST_WRONG", identity); err != ssh1.ErrPassphrase {
+		t.Errorf("got %v, want ErrPassphrase", err)
+	}
+}
+
+func TestPasswdAndStrip(t *testing.T) {
+	setPass(t, "SSH1KEY_TEST_PASS", "testit")
+	setPass(t, "SSH1KEY_TEST_NEW", "another passphrase")
+	changed, err := cli(t, nil, "passwd", "-pass-env", "SSH1KEY_TEST_PASS", "-new-pass-env", "SSH1KEY_TEST_NEW", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf, err := ssh1.ParseKeyFile(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kf.CheckPassphrase([]byte("testit")) || !kf.CheckPassphrase([]byte("another passphrase")) {
+		t.Errorf("passphrase was not changed")
+	}
+
+	stripped, err := cli(t, changed, "strip", "-pass-env", "SSH1KEY_TEST_NEW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadFile("../../ssh1/testdata/identity.plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const check = 195 // offset of the random check bytes
+	if !bytes.Equal(stripped[:check], plain[:check]) || !bytes.Equal(stripped[check+4:], plain[check+4:]) {
+		t.Errorf("stripped key differs from the unencrypted fixture")
+	}
+
+	if _, err := cli(t, nil, "passwd", "-pass-env", "SSH1KEY_TEST_PASS", identity); err == nil {
+		t.Errorf("passwd accepted an empty new passphrase")
+	}
+	if _, err := cli(t, nil, "strip", identity); err == nil {
+		t.Errorf("strip of an encrypted key without a passphrase succeeded")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	setPass(t, "SSH1KEY_TEST_PASS", "testit")
+	data, err := ioutil.ReadFile(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf, err := ssh1.ParseKeyFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := kf.Decrypt([]byte("testit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, to := range []string{"pkcs1", "pkcs8", "openssh"} {
+		out, err := cli(t, data, "convert", "-to", to, "-pass-env", "SSH1KEY_TEST_PASS")
+		if err != nil {
+			t.Fatalf("%s: %v", to, err)
+		}
+		var got interface{}
+		switch to {
+		case "pkcs1":
+			block, _ := pem.Decode(out)
+			got, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "pkcs8":
+			block, _ := pem.Decode(out)
+			got, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "openssh":
+			got, err = ssh.ParseRawPrivateKey(out)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", to, err)
+		}
+		if !key.Equal(got) {
+			t.Errorf("%s: converted key differs", to)
+		}
+	}
+
+	out, err := cli(t, data, "convert", "-to", "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssh.FingerprintSHA256(pub) != ssh.FingerprintSHA256(mustPublic(t, kf)) || comment != kf.Comment {
+		t.Errorf("public key line %q", out)
+	}
+}
+
+func mustPublic(t *testing.T, kf *ssh1.KeyFile) ssh.PublicKey {
+	pub, err := ssh.NewPublicKey(kf.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestRecover(t *testing.T) {
+	wordlist := filepath.Join(t.TempDir(), "words")
+	if err := ioutil.WriteFile(wordlist, []byte("secret\npassword\nTESTIT\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli(t, nil, "recover", "-wordlist", wordlist, identity); err != ssh1.ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	out, err := cli(t, nil, "recover", "-wordlist", wordlist, "-rules", "capitalize,lower", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "testit\n" {
+		t.Errorf("recovered %q", out)
+	}
+	if _, err := cli(t, nil, "recover", "-wordlist", wordlist, "-rules", "bogus", identity); err == nil {
+		t.Errorf("unknown rule accepted")
+	}
+}
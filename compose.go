@@ -0,0 +1,82 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import "crypto/cipher"
+
+// cascade runs its layers one after the other over the whole input.  Each
+// layer only sees its own input stream, so running a layer over all the
+// blocks before the next starts gives the same result as going block by block.
+type cascade struct {
+	layers    []cipher.BlockMode
+	blockSize int
+}
+
+func newCascade(name string, layers []cipher.BlockMode) *cascade {
+	if len(layers) == 0 {
+		panic(name + ": no layers given")
+	}
+	bs := layers[0].BlockSize()
+	for _, l := range layers[1:] {
+		if l.BlockSize() != bs {
+			panic(name + ": BlockSize must be equal for all layers")
+		}
+	}
+	return &cascade{layers: layers, blockSize: bs}
+}
+
+// Compose returns a BlockMode which runs the given modes in turn, the output
+// of each becoming the input of the next.  The layers may be any mix of
+// standard library and custom modes, but must share a block size, and must
+// not be used on their own afterwards as they keep their chaining state.
+// Inner-CBC is three CBC layers,
+//
+//	Compose(cipher.NewCBCEncrypter(b1, iv1), cipher.NewCBCDecrypter(b2, iv2),
+//	        cipher.NewCBCEncrypter(b3, iv3))
+//
+// which gives the same output as NewEncrypter(b1, b2, b3, iv1+iv2+iv3).
+func Compose(layers ...cipher.BlockMode) cipher.BlockMode {
+	return newCascade("cbc3.Compose", layers)
+}
+
+// ComposeDecrypter returns a BlockMode which undoes a composition.  The
+// layers are the inverses of the composed ones, given in the same order as to
+// Compose, and are run last to first.
+func ComposeDecrypter(layers ...cipher.BlockMode) cipher.BlockMode {
+	rev := make([]cipher.BlockMode, len(layers))
+	for i, l := range layers {
+		rev[len(layers)-1-i] = l
+	}
+	return newCascade("cbc3.ComposeDecrypter", rev)
+}
+
+func (c *cascade) BlockSize() int { return c.blockSize }
+
+func (c *cascade) CryptBlocks(dst, src []byte) {
+	if len(src)%c.blockSize != 0 {
+		panic("crypto/cipher: input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("crypto/cipher: invalid buffer overlap")
+	}
+	dst = dst[:len(src)]
+	c.layers[0].CryptBlocks(dst, src)
+	for _, l := range c.layers[1:] {
+		l.CryptBlocks(dst, dst)
+	}
+}
@@ -0,0 +1,70 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestComposeStdlibCBC(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	iv := benchkey[:24]
+	msg := bytes.Repeat([]byte("compose"), 16)[:112]
+
+	want := make([]byte, len(msg))
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, msg)
+
+	enc := cbc3.Compose(
+		cipher.NewCBCEncrypter(b1, iv[:8]),
+		cipher.NewCBCDecrypter(b2, iv[8:16]),
+		cipher.NewCBCEncrypter(b3, iv[16:]),
+	)
+	got := make([]byte, len(msg))
+	enc.CryptBlocks(got[:40], msg[:40])
+	enc.CryptBlocks(got[40:], msg[40:])
+	if !bytes.Equal(got, want) {
+		t.Fatalf("three stdlib CBC layers differ from CBC3")
+	}
+
+	dec := cbc3.ComposeDecrypter(
+		cipher.NewCBCDecrypter(b1, iv[:8]),
+		cipher.NewCBCEncrypter(b2, iv[8:16]),
+		cipher.NewCBCDecrypter(b3, iv[16:]),
+	)
+	dec.CryptBlocks(got, got)
+	if !bytes.Equal(got, msg) {
+		t.Errorf("composed decrypter did not recover the plaintext")
+	}
+}
+
+func TestComposeMixed(t *testing.T) {
+	a, _ := aes.NewCipher(benchkey[:16])
+	b, _ := aes.NewCipher(benchkey[16:32])
+	iv := benchkey[:16]
+	msg := bytes.Repeat([]byte("mixed layers...."), 4)
+
+	ct := make([]byte, len(msg))
+	cbc3.Compose(cipher.NewCBCEncrypter(a, iv), cbc3.NewLayerEncrypter(cbc3.LayerOFB, b, iv)).CryptBlocks(ct, msg)
+	pt := make([]byte, len(msg))
+	cbc3.ComposeDecrypter(cipher.NewCBCDecrypter(a, iv), cbc3.NewLayerDecrypter(cbc3.LayerOFB, b, iv)).CryptBlocks(pt, ct)
+	if !bytes.Equal(pt, msg) {
+		t.Errorf("mixed composition round trip failed")
+	}
+}
+
+func TestComposeBlockSizeMismatch(t *testing.T) {
+	a, _ := aes.NewCipher(benchkey[:16])
+	d, _ := des.NewCipher(benchkey[:8])
+	defer func() {
+		if recover() == nil {
+			t.Errorf("mismatched block sizes did not panic")
+		}
+	}()
+	cbc3.Compose(cipher.NewCBCEncrypter(a, benchkey[:16]), cipher.NewCBCEncrypter(d, benchkey[:8]))
+}
@@ -0,0 +1,388 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The container format wraps a segmented stream in a self-describing header
+// so a ciphertext at rest records how it was made.  All integers are
+// big-endian:
+//
+//   magic        "CBC3"
+//   version      uint8, currently 1
+//   suite        uint8, the three stage ciphers
+//   padding      uint8, the padding scheme of the final segment
+//   kdf          uint8, the key derivation function
+//   salt         uint8 length, then the salt
+//   iterations   uint32, KDF iterations, time or cost parameter
+//   memory       uint32, KDF memory or block size parameter
+//   parallelism  uint8, KDF parallelism
+//   key id       uint8 length, then the key identifier
+//   segment size uint32, plaintext bytes per segment
+//   iv           the triple IV, three times the suite block size
+//   header mac   HMAC-SHA-256 over all of the above
+//
+// The segments follow, with every segment tag bound to the header bytes.
+
+package cbc3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ContainerVersion is the container format version written by
+// NewContainerWriter.
+const ContainerVersion = 1
+
+// DefaultSegmentSize is the segment size used when a Header does not set one.
+const DefaultSegmentSize = 64 << 10
+
+// maxSegmentSize bounds the memory a reader will commit to a header it has
+// not authenticated yet.
+const maxSegmentSize = 16 << 20
+
+const containerMACSize = sha256.Size
+
+var containerMagic = []byte("CBC3")
+
+var (
+	// ErrContainerVersion is returned when reading a container written with
+	// a format version this package does not know.
+	ErrContainerVersion = errors.New("cbc3: unsupported container version")
+
+	errContainerMagic  = errors.New("cbc3: not a CBC3 container")
+	errContainerHeader = errors.New("cbc3: container header authentication failed")
+)
+
+// Suite identifies the block ciphers used for the three CBC3 stages.
+type Suite uint8
+
+const (
+	SuiteDES    Suite = 1 // DES in all three stages, as in SSH-1 3DES
+	SuiteAES128 Suite = 2 // AES-128 in all three stages
+	SuiteAES192 Suite = 3 // AES-192 in all three stages
+	SuiteAES256 Suite = 4 // AES-256 in all three stages
+)
+
+// KeySize returns the length of each of the three stage keys, or 0 for an
+// unknown suite.
+func (s Suite) KeySize() int {
+	switch s {
+	case SuiteDES:
+		return 8
+	case SuiteAES128:
+		return 16
+	case SuiteAES192:
+		return 24
+	case SuiteAES256:
+		return 32
+	}
+	return 0
+}
+
+// BlockSize returns the block size of the stage ciphers, or 0 for an unknown
+// suite.
+func (s Suite) BlockSize() int {
+	switch s {
+	case SuiteDES:
+		return des.BlockSize
+	case SuiteAES128, SuiteAES192, SuiteAES256:
+		return aes.BlockSize
+	}
+	return 0
+}
+
+// NewCipher returns the stage block cipher for key.
+func (s Suite) NewCipher(key []byte) (cipher.Block, error) {
+	if len(key) != s.KeySize() {
+		return nil, errKeySize
+	}
+	switch s {
+	case SuiteDES:
+		return des.NewCipher(key)
+	case SuiteAES128, SuiteAES192, SuiteAES256:
+		return aes.NewCipher(key)
+	}
+	return nil, errors.New("cbc3: unknown suite")
+}
+
+// PaddingScheme identifies the Padding of the final container segment.
+type PaddingScheme uint8
+
+const (
+	PaddingPKCS7    PaddingScheme = 1
+	PaddingANSIX923 PaddingScheme = 2
+	PaddingISO7816  PaddingScheme = 3
+)
+
+// Padding returns the Padding for the scheme, or nil for an unknown scheme.
+func (p PaddingScheme) Padding() Padding {
+	switch p {
+	case PaddingPKCS7:
+		return PKCS7Padding
+	case PaddingANSIX923:
+		return ANSIX923Padding
+	case PaddingISO7816:
+		return ISO7816Padding
+	}
+	return nil
+}
+
+// KDF identifies how the container key was derived.
+type KDF uint8
+
+const (
+	KDFNone     KDF = 0 // raw key material
+	KDFPBKDF2   KDF = 1 // PBKDF2-HMAC-SHA-256
+	KDFScrypt   KDF = 2 // scrypt
+	KDFArgon2id KDF = 3 // Argon2id
+	KDFHKDF     KDF = 4 // HKDF-SHA-256 from a high entropy secret
+)
+
+// KDFParams records the key derivation parameters in a container header.
+// Iterations holds the PBKDF2 iteration count, the scrypt cost N or the
+// Argon2id time parameter.  Memory holds the scrypt block size r or the
+// Argon2id memory in KiB.  Parallelism holds the scrypt or Argon2id
+// parallelism.
+type KDFParams struct {
+	KDF         KDF
+	Salt        []byte
+	Iterations  uint32
+	Memory      uint32
+	Parallelism uint8
+}
+
+// Header describes the contents of a container.
+type Header struct {
+	Version     uint8
+	Suite       Suite
+	Padding     PaddingScheme
+	KDF         KDFParams
+	KeyID       []byte
+	SegmentSize uint32
+	IV          []byte
+}
+
+func (h *Header) marshal() ([]byte, error) {
+	if len(h.KDF.Salt) > 255 || len(h.KeyID) > 255 {
+		return nil, errors.New("cbc3: container salt or key id too long")
+	}
+	var b bytes.Buffer
+	b.Write(containerMagic)
+	b.WriteByte(h.Version)
+	b.WriteByte(byte(h.Suite))
+	b.WriteByte(byte(h.Padding))
+	b.WriteByte(byte(h.KDF.KDF))
+	b.WriteByte(byte(len(h.KDF.Salt)))
+	b.Write(h.KDF.Salt)
+	binary.Write(&b, binary.BigEndian, h.KDF.Iterations)
+	binary.Write(&b, binary.BigEndian, h.KDF.Memory)
+	b.WriteByte(h.KDF.Parallelism)
+	b.WriteByte(byte(len(h.KeyID)))
+	b.Write(h.KeyID)
+	binary.Write(&b, binary.BigEndian, h.SegmentSize)
+	b.Write(h.IV)
+	return b.Bytes(), nil
+}
+
+// readHeader parses a header from r and returns it along with the raw bytes
+// it was read from.
+func readHeader(r io.Reader) (*Header, []byte, error) {
+	var raw bytes.Buffer
+	tr := io.TeeReader(r, &raw)
+	read := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(tr, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return buf, nil
+	}
+
+	fixed, err := read(len(containerMagic) + 5)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(fixed[:4], containerMagic) {
+		return nil, nil, errContainerMagic
+	}
+	h := &Header{
+		Version: fixed[4],
+		Suite:   Suite(fixed[5]),
+		Padding: PaddingScheme(fixed[6]),
+		KDF:     KDFParams{KDF: KDF(fixed[7])},
+	}
+	if h.Version != ContainerVersion {
+		return nil, nil, ErrContainerVersion
+	}
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	if h.KDF.Salt, err = read(int(fixed[8])); err != nil {
+		return nil, nil, err
+	}
+	kdf, err := read(10)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.KDF.Iterations = binary.BigEndian.Uint32(kdf[0:4])
+	h.KDF.Memory = binary.BigEndian.Uint32(kdf[4:8])
+	h.KDF.Parallelism = kdf[8]
+	if h.KeyID, err = read(int(kdf[9])); err != nil {
+		return nil, nil, err
+	}
+	seg, err := read(4)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.SegmentSize = binary.BigEndian.Uint32(seg)
+	if err := h.checkSegmentSize(); err != nil {
+		return nil, nil, err
+	}
+	if h.IV, err = read(3 * h.Suite.BlockSize()); err != nil {
+		return nil, nil, err
+	}
+	return h, raw.Bytes(), nil
+}
+
+func (h *Header) check() error {
+	if h.Suite.KeySize() == 0 {
+		return errors.New("cbc3: unknown container suite")
+	}
+	if h.Padding.Padding() == nil {
+		return errors.New("cbc3: unknown container padding scheme")
+	}
+	if h.KDF.KDF > KDFHKDF {
+		return errors.New("cbc3: unknown container key derivation function")
+	}
+	return nil
+}
+
+func (h *Header) checkSegmentSize() error {
+	if h.SegmentSize == 0 || h.SegmentSize > maxSegmentSize || int(h.SegmentSize)%h.Suite.BlockSize() != 0 {
+		return errors.New("cbc3: invalid container segment size")
+	}
+	return nil
+}
+
+// containerKeys splits container key material into the MAC key and the three
+// stage ciphers.
+func containerKeys(h *Header, key []byte) (macKey []byte, b1, b2, b3 cipher.Block, err error) {
+	n := h.Suite.KeySize()
+	if len(key) != containerMACSize+3*n {
+		return nil, nil, nil, nil, errKeySize
+	}
+	macKey, key = key[:containerMACSize], key[containerMACSize:]
+	if b1, err = h.Suite.NewCipher(key[:n]); err != nil {
+		return
+	}
+	if b2, err = h.Suite.NewCipher(key[n : 2*n]); err != nil {
+		return
+	}
+	b3, err = h.Suite.NewCipher(key[2*n:])
+	return
+}
+
+// NewContainerWriter writes the header h to w and returns a writer which
+// encrypts everything written to it into the container body.  The key is a 32
+// byte MAC key followed by the three stage keys of h.Suite.  Zero fields of h
+// are filled in: the version, PKCS#7 padding, DefaultSegmentSize and a random
+// triple IV.  Close must be called to write the final segment; it does not
+// close w.
+func NewContainerWriter(w io.Writer, h *Header, key []byte) (io.WriteCloser, error) {
+	if h.Version == 0 {
+		h.Version = ContainerVersion
+	}
+	if h.Version != ContainerVersion {
+		return nil, ErrContainerVersion
+	}
+	if h.Padding == 0 {
+		h.Padding = PaddingPKCS7
+	}
+	if h.SegmentSize == 0 {
+		h.SegmentSize = DefaultSegmentSize
+	}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	if err := h.checkSegmentSize(); err != nil {
+		return nil, err
+	}
+	if h.IV == nil {
+		h.IV = make([]byte, 3*h.Suite.BlockSize())
+		if _, err := io.ReadFull(rand.Reader, h.IV); err != nil {
+			return nil, err
+		}
+	}
+	if len(h.IV) != 3*h.Suite.BlockSize() {
+		return nil, errors.New("cbc3: IV length must equal three times the cipher block size")
+	}
+	macKey, b1, b2, b3, err := containerKeys(h, key)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(raw)
+	if _, err := w.Write(mac.Sum(raw)); err != nil {
+		return nil, err
+	}
+	return newSegmentWriter(w, NewEncrypter(b1, b2, b3, h.IV), h.Padding.Padding(), macKey, raw, int(h.SegmentSize)), nil
+}
+
+// NewContainerReader reads a container header from r, asks key for the key
+// material matching it, and returns the parsed header and a reader for the
+// decrypted body.  The key callback sees the header before it has been
+// authenticated and should only use it to pick a key; the header MAC is
+// checked against the returned key before anything else is done.
+func NewContainerReader(r io.Reader, key func(h *Header) ([]byte, error)) (io.Reader, *Header, error) {
+	h, raw, err := readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := key(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	macKey, b1, b2, b3, err := containerKeys(h, k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tag := make([]byte, containerMACSize)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(raw)
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		return nil, nil, errContainerHeader
+	}
+	return newSegmentReader(r, NewDecrypter(b1, b2, b3, h.IV), h.Padding.Padding(), macKey, raw, int(h.SegmentSize)), h, nil
+}
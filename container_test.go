@@ -0,0 +1,111 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func containerKey(suite cbc3.Suite) []byte {
+	key := make([]byte, 32+3*suite.KeySize())
+	for i := range key {
+		key[i] = byte(i * 13)
+	}
+	return key
+}
+
+func sealContainer(t *testing.T, h *cbc3.Header, plaintext []byte) []byte {
+	var buf bytes.Buffer
+	w, err := cbc3.NewContainerWriter(&buf, h, containerKey(h.Suite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestContainerRoundTrip(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("container body "), 300)
+	for _, suite := range []cbc3.Suite{cbc3.SuiteDES, cbc3.SuiteAES128, cbc3.SuiteAES192, cbc3.SuiteAES256} {
+		for _, padding := range []cbc3.PaddingScheme{cbc3.PaddingPKCS7, cbc3.PaddingANSIX923, cbc3.PaddingISO7816} {
+			h := &cbc3.Header{
+				Suite:       suite,
+				Padding:     padding,
+				KDF:         cbc3.KDFParams{KDF: cbc3.KDFPBKDF2, Salt: []byte("salt"), Iterations: 4096},
+				KeyID:       []byte("backup-2022"),
+				SegmentSize: 256,
+			}
+			data := sealContainer(t, h, plaintext)
+
+			r, got, err := cbc3.NewContainerReader(bytes.NewReader(data), func(h *cbc3.Header) ([]byte, error) {
+				return containerKey(h.Suite), nil
+			})
+			if err != nil {
+				t.Fatalf("suite %d padding %d: %s", suite, padding, err)
+			}
+			if got.Suite != suite || got.Padding != padding || string(got.KeyID) != "backup-2022" ||
+				got.KDF.KDF != cbc3.KDFPBKDF2 || string(got.KDF.Salt) != "salt" || got.KDF.Iterations != 4096 ||
+				got.SegmentSize != 256 || !bytes.Equal(got.IV, h.IV) {
+				t.Errorf("suite %d padding %d: header mismatch: %+v", suite, padding, got)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("suite %d padding %d: %s", suite, padding, err)
+			}
+			if !bytes.Equal(out, plaintext) {
+				t.Errorf("suite %d padding %d: round trip mismatch", suite, padding)
+			}
+		}
+	}
+}
+
+func TestContainerRejects(t *testing.T) {
+	data := sealContainer(t, &cbc3.Header{Suite: cbc3.SuiteAES128, KeyID: []byte("k1")}, []byte("secret"))
+	keys := func(h *cbc3.Header) ([]byte, error) { return containerKey(h.Suite), nil }
+	open := func(data []byte) error {
+		r, _, err := cbc3.NewContainerReader(bytes.NewReader(data), keys)
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(r)
+		return err
+	}
+
+	future := append([]byte{}, data...)
+	future[4] = 2
+	if err := open(future); err != cbc3.ErrContainerVersion {
+		t.Errorf("unknown version: got %v, want ErrContainerVersion", err)
+	}
+
+	if err := open(append([]byte("XBC3"), data[4:]...)); err == nil {
+		t.Errorf("bad magic was accepted")
+	}
+
+	// Flip a byte of the key id, which is covered by the header MAC.
+	keyID := bytes.Index(data, []byte("k1"))
+	bad := append([]byte{}, data...)
+	bad[keyID] ^= 1
+	if err := open(bad); err == nil {
+		t.Errorf("modified header was accepted")
+	}
+
+	wrongKey := func(h *cbc3.Header) ([]byte, error) {
+		k := containerKey(h.Suite)
+		k[0] ^= 1
+		return k, nil
+	}
+	if _, _, err := cbc3.NewContainerReader(bytes.NewReader(data), wrongKey); err == nil {
+		t.Errorf("wrong key was accepted")
+	}
+
+	if err := open(data[:len(data)-1]); err == nil {
+		t.Errorf("truncated body was accepted")
+	}
+}
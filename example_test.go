@@ -108,3 +108,30 @@ func ExampleNewEncrypter() {
 
 	fmt.Printf("%x\n", ciphertext)
 }
+
+func ExampleDeriveKeySetArgon2id() {
+	// The salt must be random and stored alongside the ciphertext.
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		panic(err)
+	}
+
+	// Derive independent DES keys for the three stages, a triple IV and a
+	// MAC key from the passphrase.
+	ks, err := cbc3.DeriveKeySetArgon2id([]byte("testit"), salt, 1, 64*1024, 4,
+		cbc3.KeySetSize{KeySize: 8, BlockSize: des.BlockSize, MACSize: 32})
+	if err != nil {
+		panic(err)
+	}
+	b1, b2, b3, err := ks.Blocks(des.NewCipher)
+	if err != nil {
+		panic(err)
+	}
+
+	plaintext := []byte("exampleplaintext")
+	ciphertext := make([]byte, len(plaintext))
+	mode := cbc3.NewEncrypter(b1, b2, b3, ks.IV)
+	mode.CryptBlocks(ciphertext, plaintext)
+
+	fmt.Printf("%x\n", ciphertext)
+}
@@ -0,0 +1,30 @@
+//go:build go1.18
+// +build go1.18
+
+package cbc3_test
+
+import "testing"
+
+// The fuzz targets shape arbitrary input into a key, IV, message and split
+// point and hand them to the differential check.  Run one with, for example,
+//
+//	go test -fuzz=FuzzDifferentialDES
+
+func fuzzDifferential(f *testing.F, cipherIndex int) {
+	c := diffCiphers[cipherIndex]
+	bs := 8
+	if c.keySize > 8 {
+		bs = 16
+	}
+	f.Add([]byte("key"), []byte("iv"), []byte("a message of some blocks........"), uint(1))
+	f.Add([]byte{}, []byte{}, []byte{}, uint(0))
+	f.Fuzz(func(t *testing.T, key, iv, msg []byte, split uint) {
+		msg = msg[:len(msg)-len(msg)%bs]
+		checkDifferential(t, c.newCipher, c.keySize, fit(key, 3*c.keySize), fit(iv, 3*bs), msg,
+			int(split%uint(len(msg)/bs+1)))
+	})
+}
+
+func FuzzDifferentialDES(f *testing.F)    { fuzzDifferential(f, 0) }
+func FuzzDifferentialAES128(f *testing.F) { fuzzDifferential(f, 1) }
+func FuzzDifferentialAES256(f *testing.F) { fuzzDifferential(f, 2) }
@@ -2,4 +2,7 @@ module github.com/pschou/go-cbc3
 
 go 1.17
 
-require github.com/pschou/go-cbc3 v0.0.0-20220927161413-29a957308e2d
+require (
+	golang.org/x/crypto v0.14.0
+	golang.org/x/sys v0.13.0
+)
@@ -0,0 +1,174 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// ioBufferSize is the amount of data the Writer and Reader cipher at a time.
+const ioBufferSize = 4096
+
+type writer struct {
+	w       io.Writer
+	mode    cipher.BlockMode
+	padding Padding
+	buf     []byte
+	n       int // bytes of a partial block held in buf
+	err     error
+	closed  bool
+}
+
+// NewWriter returns a writer which encrypts everything written to it with
+// mode and writes the ciphertext to w.  Writes may be of any length; a partial
+// block is held back until it is completed by a later write or padded by
+// Close.  Close must be called to flush the last block; it does not close w.
+func NewWriter(w io.Writer, mode cipher.BlockMode, padding Padding) io.WriteCloser {
+	bs := mode.BlockSize()
+	size := ioBufferSize - ioBufferSize%bs
+	if size < 2*bs {
+		size = 2 * bs
+	}
+	return &writer{
+		w:       w,
+		mode:    mode,
+		padding: padding,
+		buf:     make([]byte, size),
+	}
+}
+
+func (x *writer) Write(p []byte) (n int, err error) {
+	if x.closed {
+		return 0, errClosed
+	}
+	bs := x.mode.BlockSize()
+	for len(p) > 0 && x.err == nil {
+		m := copy(x.buf[x.n:], p)
+		x.n += m
+		n += m
+		p = p[m:]
+
+		full := x.n - x.n%bs
+		if full == 0 {
+			break
+		}
+		x.mode.CryptBlocks(x.buf[:full], x.buf[:full])
+		if _, x.err = x.w.Write(x.buf[:full]); x.err != nil {
+			break
+		}
+		x.n = copy(x.buf, x.buf[full:x.n])
+	}
+	return n, x.err
+}
+
+// Close pads the held back partial block and writes out the last of the
+// ciphertext.
+func (x *writer) Close() error {
+	if x.closed {
+		return x.err
+	}
+	x.closed = true
+	if x.err != nil {
+		return x.err
+	}
+	final := x.padding.Pad(x.buf[:x.n], x.mode.BlockSize())
+	if len(final)%x.mode.BlockSize() != 0 {
+		x.err = errors.New("cbc3: input not full blocks")
+		return x.err
+	}
+	x.mode.CryptBlocks(final, final)
+	_, x.err = x.w.Write(final)
+	return x.err
+}
+
+type reader struct {
+	r       io.Reader
+	mode    cipher.BlockMode
+	padding Padding
+	in      []byte
+	n       int // ciphertext bytes held in in
+	dec     []byte
+	out     []byte
+	eof     bool
+	err     error
+}
+
+// NewReader returns a reader which decrypts the ciphertext read from r with
+// mode.  The last block is held back until r reports io.EOF so that the
+// padding can be removed from it.  A ciphertext which is not a multiple of
+// the block size results in io.ErrUnexpectedEOF.
+func NewReader(r io.Reader, mode cipher.BlockMode, padding Padding) io.Reader {
+	bs := mode.BlockSize()
+	size := ioBufferSize - ioBufferSize%bs
+	if size < 2*bs {
+		size = 2 * bs
+	}
+	return &reader{
+		r:       r,
+		mode:    mode,
+		padding: padding,
+		in:      make([]byte, size),
+		dec:     make([]byte, size),
+	}
+}
+
+func (x *reader) Read(p []byte) (int, error) {
+	for len(x.out) == 0 {
+		if x.err != nil {
+			return 0, x.err
+		}
+		x.err = x.fill()
+	}
+	n := copy(p, x.out)
+	x.out = x.out[n:]
+	return n, nil
+}
+
+// fill decrypts the next run of ciphertext, always keeping at least the last
+// whole block in reserve until the end of the input is reached.
+func (x *reader) fill() error {
+	bs := x.mode.BlockSize()
+	for !x.eof {
+		m, err := x.r.Read(x.in[x.n:])
+		x.n += m
+		if err == io.EOF {
+			x.eof = true
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if avail := x.n - x.n%bs - bs; avail > 0 {
+			x.mode.CryptBlocks(x.dec[:avail], x.in[:avail])
+			x.n = copy(x.in, x.in[avail:x.n])
+			x.out = x.dec[:avail]
+			return nil
+		}
+	}
+
+	if x.n%bs != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	x.mode.CryptBlocks(x.dec[:x.n], x.in[:x.n])
+	out, err := x.padding.Unpad(x.dec[:x.n], bs)
+	if err != nil {
+		return err
+	}
+	x.n = 0
+	x.out = out
+	return io.EOF
+}
@@ -0,0 +1,138 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/des"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+var paddings = []struct {
+	name    string
+	padding cbc3.Padding
+}{
+	{"PKCS7", cbc3.PKCS7Padding},
+	{"ANSIX923", cbc3.ANSIX923Padding},
+	{"ISO7816", cbc3.ISO7816Padding},
+}
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	iv := make([]byte, 24)
+
+	for _, p := range paddings {
+		for _, n := range []int{0, 1, 7, 8, 9, 4095, 4096, 4097, 10000} {
+			plaintext := make([]byte, n)
+			for i := range plaintext {
+				plaintext[i] = byte(i*7 + 1)
+			}
+
+			// Write in chunks of odd sizes.
+			var buf bytes.Buffer
+			w := cbc3.NewWriter(&buf, cbc3.NewEncrypter(b1, b2, b3, iv), p.padding)
+			for i, chunk := 0, 1; i < n; chunk += 2 {
+				end := i + chunk
+				if end > n {
+					end = n
+				}
+				if _, err := w.Write(plaintext[i:end]); err != nil {
+					t.Fatal(err)
+				}
+				i = end
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The stream must match a one-shot CryptBlocks of the padded input.
+			padded := p.padding.Pad(append([]byte{}, plaintext...), 8)
+			want := make([]byte, len(padded))
+			cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, padded)
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("%s %d bytes: writer output differs from CryptBlocks", p.name, n)
+			}
+
+			for _, wrap := range []func(io.Reader) io.Reader{
+				func(r io.Reader) io.Reader { return r },
+				iotest.OneByteReader,
+				iotest.HalfReader,
+				iotest.DataErrReader,
+			} {
+				r := cbc3.NewReader(wrap(bytes.NewReader(want)), cbc3.NewDecrypter(b1, b2, b3, iv), p.padding)
+				got, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatalf("%s %d bytes: %s", p.name, n, err)
+				}
+				if !bytes.Equal(got, plaintext) {
+					t.Fatalf("%s %d bytes: round trip mismatch", p.name, n)
+				}
+			}
+		}
+	}
+}
+
+func TestWriterReaderCopy(t *testing.T) {
+	b1, _ := aes.NewCipher(benchkey[:16])
+	b2, _ := aes.NewCipher(benchkey[16:])
+	b3, _ := aes.NewCipher(benchkey[:32])
+	iv := make([]byte, 48)
+	plaintext := bytes.Repeat([]byte("odd sized input "), 1001)[:16013]
+
+	var ciphertext bytes.Buffer
+	w := cbc3.NewWriter(&ciphertext, cbc3.NewEncrypter(b1, b2, b3, iv), cbc3.PKCS7Padding)
+	if _, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(plaintext))); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var out bytes.Buffer
+	r := cbc3.NewReader(&ciphertext, cbc3.NewDecrypter(b1, b2, b3, iv), cbc3.PKCS7Padding)
+	if _, err := io.Copy(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("io.Copy round trip mismatch")
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	iv := make([]byte, 24)
+
+	r := cbc3.NewReader(bytes.NewReader(make([]byte, 13)), cbc3.NewDecrypter(b1, b1, b1, iv), cbc3.PKCS7Padding)
+	if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("partial block: got %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	w := cbc3.NewWriter(ioutil.Discard, cbc3.NewEncrypter(b1, b1, b1, iv), cbc3.NoPadding)
+	w.Write([]byte("short"))
+	if err := w.Close(); err == nil {
+		t.Errorf("NoPadding accepted a partial block")
+	}
+}
+
+func TestPaddingUnpad(t *testing.T) {
+	for _, p := range paddings {
+		for n := 0; n < 16; n++ {
+			in := bytes.Repeat([]byte{0xff}, n)
+			padded := p.padding.Pad(append([]byte{}, in...), 8)
+			if len(padded)%8 != 0 || len(padded) <= n {
+				t.Errorf("%s: bad padded length %d for %d bytes", p.name, len(padded), n)
+			}
+			out, err := p.padding.Unpad(padded, 8)
+			if err != nil || !bytes.Equal(out, in) {
+				t.Errorf("%s: unpad of %d bytes failed: %v", p.name, n, err)
+			}
+		}
+		if _, err := p.padding.Unpad(bytes.Repeat([]byte{0x11}, 8), 8); err == nil {
+			t.Errorf("%s: accepted invalid padding", p.name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Recovering the triple IV from the stage keys and a known plaintext and
+// ciphertext.  The first block is
+//
+//	a = E1(P ^ I1)
+//	C = E3(D2(a) ^ I2 ^ I3)
+//
+// after which the registers hold a, a and C.  I2 and I3 are overwritten having
+// only ever been used through I2 ^ I3, so no amount of data tells them apart:
+// any pair with the same XOR encrypts and decrypts identically.  Every later
+// block depends on I1 only through a, which the stage ciphers hide, so I1 can
+// be solved for only when both of I2 and I3 are known, and the other way
+// around.  Anything else needs a search over a whole block.
+
+package cbc3
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+)
+
+// ErrIVUnidentifiable is returned by RecoverIV when the known registers do not
+// determine the rest.
+var ErrIVUnidentifiable = errors.New("cbc3: IV cannot be recovered from the known registers")
+
+var errIVMismatch = errors.New("cbc3: no IV with the known registers matches the data")
+
+// RecoverIV returns the triple IV under which NewEncrypter(b1, b2, b3, iv)
+// encrypts plaintext to ciphertext, at least one block of each.  known holds
+// the registers I1, I2 and I3 that are known, nil for the others.  Either I1
+// or both I2 and I3 must be known:
+//
+//   - With I1 and one of I2 and I3 known, the other is solved for.
+//   - With I2 and I3 known, I1 is solved for.
+//   - With only I1 known, I2 is returned as zero and I3 as I2 ^ I3.  This is
+//     not the original IV but is equivalent to it for every message.
+//
+// A second block, when given, checks the known registers; any mismatch over
+// the data returns an error.
+func RecoverIV(b1, b2, b3 cipher.Block, known [3][]byte, plaintext, ciphertext []byte) ([]byte, error) {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		return nil, errBlockSizes
+	}
+	if len(plaintext) < bs || len(plaintext)%bs != 0 || len(ciphertext) != len(plaintext) {
+		return nil, errors.New("cbc3: RecoverIV needs equal whole blocks of plaintext and ciphertext")
+	}
+	for _, r := range known {
+		if r != nil && len(r) != bs {
+			return nil, errors.New("cbc3: known IV register length must equal the cipher block size")
+		}
+	}
+
+	iv := make([]byte, 3*bs)
+	i1, i2, i3 := iv[:bs], iv[bs:2*bs], iv[2*bs:]
+	// x = I2 ^ I3 = D3(C) ^ D2(a)
+	x := make([]byte, bs)
+	b3.Decrypt(x, ciphertext[:bs])
+
+	switch {
+	case known[0] != nil:
+		copy(i1, known[0])
+		a := make([]byte, bs)
+		xorBytes(a, plaintext[:bs], i1)
+		b1.Encrypt(a, a)
+		b2.Decrypt(a, a)
+		xorBytes(x, x, a)
+		switch {
+		case known[1] != nil:
+			copy(i2, known[1])
+			xorBytes(i3, x, i2)
+			if known[2] != nil && !bytes.Equal(i3, known[2]) {
+				return nil, errIVMismatch
+			}
+		case known[2] != nil:
+			copy(i3, known[2])
+			xorBytes(i2, x, i3)
+		default:
+			copy(i3, x)
+		}
+	case known[1] != nil && known[2] != nil:
+		copy(i2, known[1])
+		copy(i3, known[2])
+		xorBytes(i1, x, i2)
+		xorBytes(i1, i1, i3)
+		b2.Encrypt(i1, i1)
+		b1.Decrypt(i1, i1)
+		xorBytes(i1, i1, plaintext[:bs])
+	default:
+		return nil, ErrIVUnidentifiable
+	}
+
+	out := make([]byte, len(plaintext))
+	NewEncrypter(b1, b2, b3, iv).CryptBlocks(out, plaintext)
+	if !bytes.Equal(out, ciphertext) {
+		return nil, errIVMismatch
+	}
+	return iv, nil
+}
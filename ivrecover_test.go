@@ -0,0 +1,86 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func randBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRecoverIV(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		keySize   int
+		newCipher func([]byte) (cipher.Block, error)
+	}{
+		{"DES", 8, des.NewCipher},
+		{"AES", 16, aes.NewCipher},
+	} {
+		for trial := 0; trial < 20; trial++ {
+			var bl [3]cipher.Block
+			for i := range bl {
+				bl[i], _ = c.newCipher(randBytes(t, c.keySize))
+			}
+			bs := bl[0].BlockSize()
+			iv := randBytes(t, 3*bs)
+			reg := [3][]byte{iv[:bs], iv[bs : 2*bs], iv[2*bs:]}
+			pt := randBytes(t, 2*bs)
+			ct := make([]byte, len(pt))
+			cbc3.NewEncrypter(bl[0], bl[1], bl[2], iv).CryptBlocks(ct, pt)
+
+			for _, known := range [][3][]byte{
+				{reg[0], reg[1], nil},
+				{reg[0], nil, reg[2]},
+				{nil, reg[1], reg[2]},
+				reg,
+			} {
+				got, err := cbc3.RecoverIV(bl[0], bl[1], bl[2], known, pt, ct)
+				if err != nil {
+					t.Fatalf("%s: %v", c.name, err)
+				}
+				if !bytes.Equal(got, iv) {
+					t.Fatalf("%s: recovered %x, want %x", c.name, got, iv)
+				}
+			}
+
+			// With only I1 the result is equivalent, not equal: another
+			// message encrypts the same under both.
+			got, err := cbc3.RecoverIV(bl[0], bl[1], bl[2], [3][]byte{reg[0], nil, nil}, pt[:bs], ct[:bs])
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			msg := randBytes(t, 4*bs)
+			want, out := make([]byte, len(msg)), make([]byte, len(msg))
+			cbc3.NewEncrypter(bl[0], bl[1], bl[2], iv).CryptBlocks(want, msg)
+			cbc3.NewEncrypter(bl[0], bl[1], bl[2], got).CryptBlocks(out, msg)
+			if !bytes.Equal(out, want) {
+				t.Fatalf("%s: equivalent IV %x encrypts differently", c.name, got)
+			}
+
+			for _, known := range [][3][]byte{{}, {nil, reg[1], nil}, {nil, nil, reg[2]}} {
+				if _, err := cbc3.RecoverIV(bl[0], bl[1], bl[2], known, pt, ct); err != cbc3.ErrIVUnidentifiable {
+					t.Errorf("%s: got %v, want ErrIVUnidentifiable", c.name, err)
+				}
+			}
+
+			// A wrong I1 passes the first block but not the second.
+			wrong := append([]byte(nil), reg[0]...)
+			wrong[0] ^= 1
+			if _, err := cbc3.RecoverIV(bl[0], bl[1], bl[2], [3][]byte{wrong, nil, nil}, pt, ct); err == nil {
+				t.Errorf("%s: wrong I1 accepted", c.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// IVSource produces the triple IV for each new message.
+type IVSource interface {
+	// NextIV returns a triple IV for encrypting plaintext.  Only sources
+	// which derive the IV from the message look at plaintext.
+	NextIV(plaintext []byte) ([]byte, error)
+}
+
+// SetNextIV draws the next IV from src and installs it into mode with
+// SetIV.  The IV is returned so it can be sent along with the ciphertext.
+func SetNextIV(mode cipher.BlockMode, src IVSource, plaintext []byte) ([]byte, error) {
+	m, ok := mode.(interface{ SetIV([]byte) })
+	if !ok {
+		return nil, errors.New("cbc3: mode does not support SetIV")
+	}
+	iv, err := src.NextIV(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	m.SetIV(iv)
+	return iv, nil
+}
+
+type randomIV struct {
+	size int
+	rand io.Reader
+}
+
+// NewRandomIV returns a source of fully random triple IVs for stage ciphers
+// of the given block size, read from crypto/rand.
+func NewRandomIV(blockSize int) IVSource {
+	return &randomIV{size: 3 * blockSize, rand: rand.Reader}
+}
+
+func (r *randomIV) NextIV([]byte) ([]byte, error) {
+	iv := make([]byte, r.size)
+	if _, err := io.ReadFull(r.rand, iv); err != nil {
+		return nil, err
+	}
+	return iv, nil
+}
+
+// CounterIV produces triple IVs by encrypting a message counter.  Register i
+// of the IV for counter c is the encryption under the block of c in big-endian
+// form, filling all but the last byte, followed by the byte i, so IVs never
+// repeat while the counter does not.  With 8 byte blocks the counter is
+// limited to 56 bits.  The block should be keyed with a key derived separately
+// from the stage keys, for example the MAC key of a KeySet.
+//
+// CounterIV is safe for concurrent use.
+type CounterIV struct {
+	b    cipher.Block
+	save func(next uint64) error
+
+	mu   sync.Mutex
+	next uint64
+}
+
+// NewCounterIV returns a CounterIV which starts from counter next.  If save is
+// not nil it is called with the following counter value before each IV is
+// handed out, so that the counter can be persisted; an error from save is
+// returned and the IV withheld.
+func NewCounterIV(b cipher.Block, next uint64, save func(next uint64) error) *CounterIV {
+	if b.BlockSize() < 8 {
+		panic("cbc3.NewCounterIV: block size too small for the counter")
+	}
+	return &CounterIV{b: b, save: save, next: next}
+}
+
+func (c *CounterIV) NextIV([]byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bs := c.b.BlockSize()
+	if c.next == ^uint64(0) || bs < 9 && c.next >= 1<<(8*uint(bs-1))-1 {
+		return nil, errors.New("cbc3: IV counter exhausted")
+	}
+	if c.save != nil {
+		if err := c.save(c.next + 1); err != nil {
+			return nil, err
+		}
+	}
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], c.next)
+	iv := make([]byte, 3*bs)
+	for i := 0; i < 3; i++ {
+		reg := iv[i*bs : (i+1)*bs]
+		if bs > 8 {
+			copy(reg[bs-9:], ctr[:])
+		} else {
+			copy(reg, ctr[1:])
+		}
+		reg[bs-1] = byte(i)
+		c.b.Encrypt(reg, reg)
+	}
+	c.next++
+	return iv, nil
+}
+
+// Counter returns the counter value the next IV will be made from.
+func (c *CounterIV) Counter() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.next
+}
+
+// ExpandIV turns a single block IV into a triple IV by encrypting it under
+// each of the stage ciphers, so only one block needs to be sent with the
+// message.  The receiver recomputes the triple IV with the same call.
+func ExpandIV(b1, b2, b3 cipher.Block, iv []byte) []byte {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		panic("cbc3.ExpandIV: BlockSize must be equal for all three block ciphers")
+	}
+	if len(iv) != bs {
+		panic("cbc3.ExpandIV: IV length must equal the cipher block size")
+	}
+	out := make([]byte, 3*bs)
+	b1.Encrypt(out[:bs], iv)
+	b2.Encrypt(out[bs:2*bs], iv)
+	b3.Encrypt(out[2*bs:], iv)
+	return out
+}
+
+type expandedIV struct {
+	b1, b2, b3 cipher.Block
+	rand       io.Reader
+}
+
+// NewExpandedIV returns a source which draws a random single block IV and
+// expands it with ExpandIV.
+func NewExpandedIV(b1, b2, b3 cipher.Block) IVSource {
+	return &expandedIV{b1: b1, b2: b2, b3: b3, rand: rand.Reader}
+}
+
+func (e *expandedIV) NextIV([]byte) ([]byte, error) {
+	iv := make([]byte, e.b1.BlockSize())
+	if _, err := io.ReadFull(e.rand, iv); err != nil {
+		return nil, err
+	}
+	return ExpandIV(e.b1, e.b2, e.b3, iv), nil
+}
+
+// SyntheticIV derives the triple IV deterministically from the plaintext
+// with HMAC-SHA-256, in the spirit of SIV mode.  Encrypting the same plaintext
+// twice gives the same ciphertext, which suits deduplicating storage and
+// tolerates a broken random number generator, but reveals when two messages
+// are equal.  The MAC key must be independent of the stage keys.
+type SyntheticIV struct {
+	key  []byte
+	size int
+}
+
+// NewSyntheticIV returns a SyntheticIV for stage ciphers of the given block
+// size.
+func NewSyntheticIV(macKey []byte, blockSize int) *SyntheticIV {
+	return &SyntheticIV{key: dup(macKey), size: 3 * blockSize}
+}
+
+// NextIV returns the concatenation of HMAC(key, i || plaintext) for i = 1,
+// 2, ... truncated to the triple IV length.
+func (s *SyntheticIV) NextIV(plaintext []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	iv := make([]byte, 0, s.size+mac.Size())
+	for i := byte(1); len(iv) < s.size; i++ {
+		mac.Reset()
+		mac.Write([]byte{i})
+		mac.Write(plaintext)
+		iv = mac.Sum(iv)
+	}
+	return iv[:s.size], nil
+}
+
+// Verify reports whether iv is the synthetic IV of plaintext, which lets a
+// receiver check a decrypted message against the IV it arrived with.
+func (s *SyntheticIV) Verify(iv, plaintext []byte) bool {
+	want, _ := s.NextIV(plaintext)
+	return hmac.Equal(iv, want)
+}
@@ -0,0 +1,108 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/des"
+	"errors"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestIVSources(t *testing.T) {
+	b1, _ := aes.NewCipher(benchkey[:16])
+	b2, _ := aes.NewCipher(benchkey[16:])
+	b3, _ := aes.NewCipher(benchkey[8:24])
+	ivKey, _ := aes.NewCipher(benchkey[:32])
+
+	var saved uint64
+	sources := map[string]cbc3.IVSource{
+		"random":    cbc3.NewRandomIV(16),
+		"counter":   cbc3.NewCounterIV(ivKey, 0, func(next uint64) error { saved = next; return nil }),
+		"expanded":  cbc3.NewExpandedIV(b1, b2, b3),
+		"synthetic": cbc3.NewSyntheticIV([]byte("siv key"), 16),
+	}
+	for name, src := range sources {
+		seen := map[string]bool{}
+		for i := 0; i < 10; i++ {
+			plaintext := bytes.Repeat([]byte{byte(i)}, 32)
+			mode := cbc3.NewEncrypter(b1, b2, b3, make([]byte, 48))
+			iv, err := cbc3.SetNextIV(mode, src, plaintext)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if len(iv) != 48 {
+				t.Fatalf("%s: IV length %d", name, len(iv))
+			}
+			if seen[string(iv)] {
+				t.Errorf("%s: IV repeated for a different message", name)
+			}
+			seen[string(iv)] = true
+
+			// SetNextIV must have installed the IV.
+			got := make([]byte, 32)
+			mode.CryptBlocks(got, plaintext)
+			want := make([]byte, 32)
+			cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, plaintext)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: IV was not applied with SetIV", name)
+			}
+		}
+	}
+	if saved != 10 {
+		t.Errorf("counter persisted %d, want 10", saved)
+	}
+}
+
+func TestCounterIV(t *testing.T) {
+	ivKey, _ := aes.NewCipher(benchkey[:16])
+	a, _ := cbc3.NewCounterIV(ivKey, 42, nil).NextIV(nil)
+	b, _ := cbc3.NewCounterIV(ivKey, 42, nil).NextIV(nil)
+	if !bytes.Equal(a, b) {
+		t.Errorf("counter IV is not reproducible")
+	}
+
+	failing := cbc3.NewCounterIV(ivKey, 7, func(uint64) error { return errors.New("disk full") })
+	if _, err := failing.NextIV(nil); err == nil || failing.Counter() != 7 {
+		t.Errorf("IV handed out without persisting the counter")
+	}
+}
+
+func TestExpandIV(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	seed := []byte("12345678")
+	iv := cbc3.ExpandIV(b1, b2, b3, seed)
+	for i, b := range []interface{ Encrypt(dst, src []byte) }{b1, b2, b3} {
+		want := make([]byte, 8)
+		b.Encrypt(want, seed)
+		if !bytes.Equal(iv[8*i:8*i+8], want) {
+			t.Errorf("register %d is not the seed encrypted under b%d", i+1, i+1)
+		}
+	}
+}
+
+func TestSyntheticIV(t *testing.T) {
+	s := cbc3.NewSyntheticIV([]byte("siv key"), 16)
+	a, _ := s.NextIV([]byte("same message"))
+	b, _ := s.NextIV([]byte("same message"))
+	if !bytes.Equal(a, b) {
+		t.Errorf("synthetic IV is not deterministic")
+	}
+	if !s.Verify(a, []byte("same message")) || s.Verify(a, []byte("other message")) {
+		t.Errorf("Verify gave the wrong answer")
+	}
+}
+
+func TestCounterIVDES(t *testing.T) {
+	ivKey, _ := des.NewCipher(benchkey[:8])
+	c := cbc3.NewCounterIV(ivKey, 1<<56-2, nil)
+	if _, err := c.NextIV(nil); err != nil {
+		t.Fatalf("last 56 bit counter value: %s", err)
+	}
+	if _, err := c.NextIV(nil); err == nil {
+		t.Errorf("counter wrapped instead of reporting exhaustion")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrIVReuse is returned when an encrypter is registered with a key set and
+// triple IV which the IVTracker has already seen.
+var ErrIVReuse = errors.New("cbc3: triple IV reused with the same keys")
+
+// IVTracker spots encrypters which start from the same triple IV under the
+// same three stage keys.  It never stores keys or IVs: each registration is
+// reduced to an HMAC, under a random key private to the tracker, of the
+// stage ciphers' outputs on a fixed probe block and of the IV.  At most a fixed
+// number of fingerprints are remembered; once full the oldest is forgotten.
+//
+// An IVTracker is safe for concurrent use.
+type IVTracker struct {
+	// OnReuse, if set, is called with the fingerprint of a repeated
+	// registration.  Its result is returned from Register in place of
+	// ErrIVReuse, so returning nil lets the encrypter be built anyway.
+	OnReuse func(fingerprint []byte) error
+
+	mu    sync.Mutex
+	key   []byte
+	seen  map[[sha256.Size]byte]struct{}
+	order [][sha256.Size]byte
+	next  int
+}
+
+// NewIVTracker returns a tracker remembering up to capacity fingerprints.
+func NewIVTracker(capacity int) *IVTracker {
+	if capacity <= 0 {
+		panic("cbc3.NewIVTracker: capacity must be positive")
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(err)
+	}
+	return &IVTracker{
+		key:   key,
+		seen:  make(map[[sha256.Size]byte]struct{}, capacity),
+		order: make([][sha256.Size]byte, 0, capacity),
+	}
+}
+
+// Register records the stage ciphers and initial triple IV of an encrypter.
+// It returns ErrIVReuse, or the result of OnReuse, if the same combination
+// was registered before and has not yet been forgotten.
+func (t *IVTracker) Register(b1, b2, b3 cipher.Block, iv []byte) error {
+	fp := t.fingerprint(b1, b2, b3, iv)
+
+	t.mu.Lock()
+	_, dup := t.seen[fp]
+	if !dup {
+		if len(t.order) < cap(t.order) {
+			t.order = append(t.order, fp)
+		} else {
+			delete(t.seen, t.order[t.next])
+			t.order[t.next] = fp
+			t.next = (t.next + 1) % len(t.order)
+		}
+		t.seen[fp] = struct{}{}
+	}
+	t.mu.Unlock()
+
+	if !dup {
+		return nil
+	}
+	if t.OnReuse != nil {
+		return t.OnReuse(fp[:])
+	}
+	return ErrIVReuse
+}
+
+// NewEncrypter registers the configuration and, if it is not a repeat,
+// returns a CBC3 encrypter for it.
+func (t *IVTracker) NewEncrypter(b1, b2, b3 cipher.Block, iv []byte) (cipher.BlockMode, error) {
+	mode := NewEncrypter(b1, b2, b3, iv)
+	if err := t.Register(b1, b2, b3, iv); err != nil {
+		return nil, err
+	}
+	return mode, nil
+}
+
+// fingerprint identifies the keys of the stage ciphers by their output on a
+// fixed probe block, in the same way as a key check value, and mixes in the
+// IV under the tracker key.
+func (t *IVTracker) fingerprint(b1, b2, b3 cipher.Block, iv []byte) (fp [sha256.Size]byte) {
+	mac := hmac.New(sha256.New, t.key)
+	probe := make([]byte, b1.BlockSize())
+	out := make([]byte, len(probe))
+	for _, b := range []cipher.Block{b1, b2, b3} {
+		b.Encrypt(out, probe)
+		mac.Write(out)
+	}
+	mac.Write(iv)
+	mac.Sum(fp[:0])
+	return
+}
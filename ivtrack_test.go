@@ -0,0 +1,63 @@
+package cbc3_test
+
+import (
+	"crypto/des"
+	"errors"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestIVTracker(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	other, _ := des.NewCipher(benchkey[24:32])
+	iv := []byte("0123456789abcdefghijklmn")
+	iv2 := []byte("0123456789abcdefghijklmo")
+
+	tr := cbc3.NewIVTracker(2)
+	if _, err := tr.NewEncrypter(b1, b2, b3, iv); err != nil {
+		t.Fatalf("first registration: %s", err)
+	}
+	if _, err := tr.NewEncrypter(b1, b2, b3, iv); err != cbc3.ErrIVReuse {
+		t.Errorf("repeated IV: got %v, want ErrIVReuse", err)
+	}
+	if err := tr.Register(b1, b2, other, iv); err != nil {
+		t.Errorf("different keys with the same IV: %s", err)
+	}
+
+	// The tracker holds two fingerprints, so a third registration forgets
+	// the first one.
+	if err := tr.Register(b1, b2, b3, iv2); err != nil {
+		t.Errorf("new IV: %s", err)
+	}
+	if err := tr.Register(b1, b2, b3, iv); err != nil {
+		t.Errorf("evicted fingerprint was still remembered: %s", err)
+	}
+}
+
+func TestIVTrackerHook(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	iv := make([]byte, 24)
+	hookErr := errors.New("hook")
+	var calls int
+
+	tr := cbc3.NewIVTracker(10)
+	tr.OnReuse = func(fp []byte) error {
+		calls++
+		if len(fp) != 32 {
+			t.Errorf("fingerprint length %d", len(fp))
+		}
+		return hookErr
+	}
+	tr.Register(b1, b1, b1, iv)
+	if err := tr.Register(b1, b1, b1, iv); err != hookErr || calls != 1 {
+		t.Errorf("hook was not used: err %v calls %d", err, calls)
+	}
+
+	tr.OnReuse = func([]byte) error { return nil }
+	if _, err := tr.NewEncrypter(b1, b1, b1, iv); err != nil {
+		t.Errorf("hook returning nil should allow the encrypter: %s", err)
+	}
+}
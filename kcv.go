@@ -0,0 +1,124 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// KCVMethod selects how a key check value is computed.
+type KCVMethod int
+
+const (
+	// KCVZeroBlock encrypts a block of zeros and keeps the leading bytes,
+	// the traditional check value for DES and triple DES keys.
+	KCVZeroBlock KCVMethod = iota
+
+	// KCVCMAC computes the CMAC of a block of zeros and keeps the leading
+	// bytes, the check value for AES keys in ANSI X9.24-1:2017.
+	KCVCMAC
+)
+
+var errKCVMethod = errors.New("cbc3: unknown key check value method")
+
+func (m KCVMethod) valid() bool { return m == KCVZeroBlock || m == KCVCMAC }
+
+// KCV returns the first n bytes of the key check value of the key behind b.
+// Partners commonly exchange 3 bytes for DES keys and 5 bytes for AES keys.
+func KCV(b cipher.Block, method KCVMethod, n int) []byte {
+	if n <= 0 || n > b.BlockSize() {
+		panic("cbc3.KCV: length out of range")
+	}
+	zero := make([]byte, b.BlockSize())
+	var out []byte
+	switch method {
+	case KCVZeroBlock:
+		out = make([]byte, len(zero))
+		b.Encrypt(out, zero)
+	case KCVCMAC:
+		mac := NewCMAC(b)
+		mac.Write(zero)
+		out = mac.Sum(nil)
+	default:
+		panic("cbc3.KCV: unknown method")
+	}
+	return out[:n]
+}
+
+// KCVs returns the n byte key check values of the three stage keys, or an
+// error if n is not between one and the block size or method is unknown.
+func (ks *KeySet) KCVs(newCipher func(key []byte) (cipher.Block, error), method KCVMethod, n int) (kcv [3][]byte, err error) {
+	if !method.valid() {
+		return kcv, errKCVMethod
+	}
+	b1, b2, b3, err := ks.Blocks(newCipher)
+	if err != nil {
+		return kcv, err
+	}
+	for i, b := range []cipher.Block{b1, b2, b3} {
+		if n <= 0 || n > b.BlockSize() {
+			return kcv, fmt.Errorf("cbc3: key check value length %d out of range for K%d", n, i+1)
+		}
+		kcv[i] = KCV(b, method, n)
+	}
+	return kcv, nil
+}
+
+// VerifyKCVs checks the stage keys against the expected key check values,
+// computing each with the length of the expected value.  A nil entry skips
+// that stage; any other entry must be between one byte and a block long.  It
+// returns an error naming the first stage which does not match.
+func (ks *KeySet) VerifyKCVs(newCipher func(key []byte) (cipher.Block, error), method KCVMethod, expected [3][]byte) error {
+	if !method.valid() {
+		return errKCVMethod
+	}
+	b1, b2, b3, err := ks.Blocks(newCipher)
+	if err != nil {
+		return err
+	}
+	for i, b := range []cipher.Block{b1, b2, b3} {
+		if expected[i] == nil {
+			continue
+		}
+		if n := len(expected[i]); n == 0 || n > b.BlockSize() {
+			return fmt.Errorf("cbc3: key check value length %d out of range for K%d", n, i+1)
+		}
+		if subtle.ConstantTimeCompare(KCV(b, method, len(expected[i])), expected[i]) != 1 {
+			return fmt.Errorf("cbc3: key check value mismatch for K%d", i+1)
+		}
+	}
+	return nil
+}
+
+// NewVerifiedEncrypter verifies the stage keys against the expected key check
+// values and only then returns a CBC3 encrypter, with ks.IV when iv is nil.
+func (ks *KeySet) NewVerifiedEncrypter(newCipher func(key []byte) (cipher.Block, error), method KCVMethod, expected [3][]byte, iv []byte) (cipher.BlockMode, error) {
+	if err := ks.VerifyKCVs(newCipher, method, expected); err != nil {
+		return nil, err
+	}
+	return ks.NewEncrypter(newCipher, iv)
+}
+
+// NewVerifiedDecrypter verifies the stage keys against the expected key check
+// values and only then returns a CBC3 decrypter, with ks.IV when iv is nil.
+func (ks *KeySet) NewVerifiedDecrypter(newCipher func(key []byte) (cipher.Block, error), method KCVMethod, expected [3][]byte, iv []byte) (cipher.BlockMode, error) {
+	if err := ks.VerifyKCVs(newCipher, method, expected); err != nil {
+		return nil, err
+	}
+	return ks.NewDecrypter(newCipher, iv)
+}
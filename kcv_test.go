@@ -0,0 +1,97 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/des"
+	"encoding/hex"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestCMACRFC4493(t *testing.T) {
+	b, _ := aes.NewCipher(hexKey("2b7e151628aed2a6abf7158809cf4f3c"))
+	msg := hexKey("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		mac := cbc3.NewCMAC(b)
+		// Feed one byte at a time to exercise the last block handling.
+		for i := 0; i < tc.n; i++ {
+			mac.Write(msg[i : i+1])
+		}
+		if got := hex.EncodeToString(mac.Sum(nil)); got != tc.want {
+			t.Errorf("%d bytes: got %s, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestKCV(t *testing.T) {
+	b, _ := des.NewCipher(hexKey("0123456789abcdef"))
+	if got := cbc3.KCV(b, cbc3.KCVZeroBlock, 3); !bytes.Equal(got, hexKey("d5d44f")) {
+		t.Errorf("DES KCV got %x, want d5d44f", got)
+	}
+
+	a, _ := aes.NewCipher(hexKey("2b7e151628aed2a6abf7158809cf4f3c"))
+	mac := cbc3.NewCMAC(a)
+	mac.Write(make([]byte, 16))
+	if got := cbc3.KCV(a, cbc3.KCVCMAC, 5); !bytes.Equal(got, mac.Sum(nil)[:5]) {
+		t.Errorf("AES CMAC KCV got %x", got)
+	}
+}
+
+func TestVerifyKCVs(t *testing.T) {
+	ks := &cbc3.KeySet{
+		K1: hexKey("0123456789abcdef"),
+		K2: hexKey("fedcba9876543210"),
+		K3: hexKey("89abcdef01234567"),
+		IV: make([]byte, 24),
+	}
+	kcvs, err := ks.KCVs(des.NewCipher, cbc3.KCVZeroBlock, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kcvs[0], hexKey("d5d44f")) {
+		t.Errorf("K1 KCV got %x", kcvs[0])
+	}
+	if _, err := ks.NewVerifiedEncrypter(des.NewCipher, cbc3.KCVZeroBlock, kcvs, nil); err != nil {
+		t.Errorf("matching KCVs were refused: %s", err)
+	}
+
+	bad := kcvs
+	bad[2] = hexKey("000000")
+	if _, err := ks.NewVerifiedDecrypter(des.NewCipher, cbc3.KCVZeroBlock, bad, nil); err == nil {
+		t.Errorf("mismatching KCV was accepted")
+	}
+	if err := ks.VerifyKCVs(des.NewCipher, cbc3.KCVZeroBlock, [3][]byte{kcvs[0], nil, nil}); err != nil {
+		t.Errorf("partial verification failed: %s", err)
+	}
+
+	// Malformed check values are errors, not panics.
+	for _, tc := range []struct {
+		name     string
+		method   cbc3.KCVMethod
+		expected [3][]byte
+	}{
+		{"empty", cbc3.KCVZeroBlock, [3][]byte{{}, nil, nil}},
+		{"longer than a block", cbc3.KCVZeroBlock, [3][]byte{nil, make([]byte, 9), nil}},
+		{"unknown method", cbc3.KCVMethod(7), kcvs},
+	} {
+		if err := ks.VerifyKCVs(des.NewCipher, tc.method, tc.expected); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+	if _, err := ks.KCVs(des.NewCipher, cbc3.KCVZeroBlock, 0); err == nil {
+		t.Errorf("KCVs of length 0 gave no error")
+	}
+	if _, err := ks.KCVs(des.NewCipher, cbc3.KCVMethod(7), 3); err == nil {
+		t.Errorf("KCVs with an unknown method gave no error")
+	}
+}
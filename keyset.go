@@ -0,0 +1,337 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Key derivation into a KeySet.  Each password based function first derives a
+// 32 byte pseudorandom key, which HKDF-Expand then stretches into the stage
+// keys, IVs and MAC key under distinct labels, so every piece of key material
+// is independent of the others.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeySet holds the key material for the three CBC3 stages, and optionally a
+// triple IV and a MAC key.
+type KeySet struct {
+	K1, K2, K3 []byte // stage keys for b1, b2 and b3
+	IV         []byte // triple IV, nil when not derived
+	MACKey     []byte // MAC key, nil when not derived
+
+	locked *LockedBuffer // holds the above after Lock
+}
+
+// KeySetSize gives the lengths of the key material to derive.
+type KeySetSize struct {
+	KeySize   int // length of each stage key
+	BlockSize int // stage block size; when non-zero a triple IV is derived
+	MACSize   int // when non-zero a MAC key of this length is derived
+}
+
+const prkSize = 32
+
+// DeriveKeySetPBKDF2 derives a KeySet from a password with PBKDF2-HMAC-SHA-256.
+func DeriveKeySetPBKDF2(password, salt []byte, iter int, size KeySetSize) (*KeySet, error) {
+	return expandKeySet(pbkdf2.Key(password, salt, iter, prkSize, sha256.New), nil, size)
+}
+
+// DeriveKeySetScrypt derives a KeySet from a password with scrypt.  See
+// golang.org/x/crypto/scrypt for the choice of N, r and p.
+func DeriveKeySetScrypt(password, salt []byte, N, r, p int, size KeySetSize) (*KeySet, error) {
+	prk, err := scrypt.Key(password, salt, N, r, p, prkSize)
+	if err != nil {
+		return nil, err
+	}
+	return expandKeySet(prk, nil, size)
+}
+
+// DeriveKeySetArgon2id derives a KeySet from a password with Argon2id.  memory
+// is in KiB.  See golang.org/x/crypto/argon2 for the choice of parameters.
+func DeriveKeySetArgon2id(password, salt []byte, time, memory uint32, threads uint8, size KeySetSize) (*KeySet, error) {
+	if time == 0 || threads == 0 {
+		return nil, errors.New("cbc3: Argon2id time and threads must be at least 1")
+	}
+	return expandKeySet(argon2.IDKey(password, salt, time, memory, threads, prkSize), nil, size)
+}
+
+// DeriveKeySetHKDF derives a KeySet from a high entropy secret, such as a
+// Diffie-Hellman shared secret, with HKDF-SHA-256.  It must not be used with
+// passwords.  info is mixed into every label.
+func DeriveKeySetHKDF(secret, salt, info []byte, size KeySetSize) (*KeySet, error) {
+	return expandKeySet(hkdf.Extract(sha256.New, secret, salt), info, size)
+}
+
+// SSH1KeySet returns the legacy key set used by SSH-1 to protect private key
+// files with 3DES: the MD5 hash of the passphrase gives K1 and K2, K3 repeats
+// K1, and the triple IV is all zeros.  It is provided for compatibility only.
+func SSH1KeySet(passphrase []byte) *KeySet {
+	h := md5.Sum(passphrase)
+	return &KeySet{
+		K1: dup(h[:8]),
+		K2: dup(h[8:]),
+		K3: dup(h[:8]),
+		IV: make([]byte, 24),
+	}
+}
+
+func expandKeySet(prk, info []byte, size KeySetSize) (*KeySet, error) {
+	if size.KeySize <= 0 || size.BlockSize < 0 || size.MACSize < 0 {
+		return nil, errKeySize
+	}
+	expand := func(label string, n int) ([]byte, error) {
+		out := make([]byte, n)
+		_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, append([]byte(label), info...)), out)
+		return out, err
+	}
+
+	var ks KeySet
+	var err error
+	if ks.K1, err = expand("cbc3 key 1", size.KeySize); err != nil {
+		return nil, err
+	}
+	if ks.K2, err = expand("cbc3 key 2", size.KeySize); err != nil {
+		return nil, err
+	}
+	if ks.K3, err = expand("cbc3 key 3", size.KeySize); err != nil {
+		return nil, err
+	}
+	if size.BlockSize > 0 {
+		if ks.IV, err = expand("cbc3 iv", 3*size.BlockSize); err != nil {
+			return nil, err
+		}
+	}
+	if size.MACSize > 0 {
+		if ks.MACKey, err = expand("cbc3 mac", size.MACSize); err != nil {
+			return nil, err
+		}
+	}
+	return &ks, nil
+}
+
+// Next derives the key set for the following key period of a stream which
+// rotates its keys while the chaining continues.  The MAC key, if any, and
+// each stage key are replaced by HKDF-SHA-256 of all the current key material
+// under a label per key, keeping their lengths; the IV is left nil, as the
+// chaining state carries over.  Knowing the next keys does not reveal the
+// current ones, so a leaked key exposes only its own period and later ones.
+// Each key must be at most 8160 bytes, the most HKDF-SHA-256 can expand.
+//
+// Used with Rekey:
+//
+//	if ks, err = ks.Next(); err != nil {
+//		return err
+//	}
+//	ks.Rekey(mode, aes.NewCipher)
+func (ks *KeySet) Next() (*KeySet, error) {
+	keys := [][]byte{ks.K1, ks.K2, ks.K3, ks.MACKey}
+	n := 0
+	for _, k := range keys {
+		if len(k) > 255*sha256.Size {
+			return nil, errKeySize
+		}
+		n += 2 + len(k)
+	}
+	// Each key is prefixed with its 2 byte length, which the check above
+	// keeps from wrapping.
+	secret := make([]byte, 0, n)
+	for _, k := range keys {
+		secret = append(secret, byte(len(k)>>8), byte(len(k)))
+		secret = append(secret, k...)
+	}
+	prk := hkdf.Extract(sha256.New, secret, nil)
+	for i := range secret {
+		secret[i] = 0
+	}
+	defer func() {
+		for i := range prk {
+			prk[i] = 0
+		}
+	}()
+
+	expand := func(label string, n int) ([]byte, error) {
+		if n == 0 {
+			return nil, nil
+		}
+		out := make([]byte, n)
+		_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(label)), out)
+		return out, err
+	}
+
+	var next KeySet
+	var err error
+	if next.K1, err = expand("cbc3 rekey 1", len(ks.K1)); err != nil {
+		return nil, err
+	}
+	if next.K2, err = expand("cbc3 rekey 2", len(ks.K2)); err != nil {
+		return nil, err
+	}
+	if next.K3, err = expand("cbc3 rekey 3", len(ks.K3)); err != nil {
+		return nil, err
+	}
+	if next.MACKey, err = expand("cbc3 rekey mac", len(ks.MACKey)); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// Rekey builds the stage ciphers of ks with newCipher and installs them in
+// mode, whose IV registers carry on.
+func (ks *KeySet) Rekey(mode Mode, newCipher func(key []byte) (cipher.Block, error)) error {
+	b1, b2, b3, err := ks.Blocks(newCipher)
+	if err != nil {
+		return err
+	}
+	if bs := mode.BlockSize(); b1.BlockSize() != bs || b2.BlockSize() != bs || b3.BlockSize() != bs {
+		return errBlockSizes
+	}
+	mode.Rekey(b1, b2, b3, nil)
+	return nil
+}
+
+// Blocks builds the three stage ciphers with newCipher, for example
+// des.NewCipher or aes.NewCipher, ready to be passed to NewEncrypter or
+// NewDecrypter.
+func (ks *KeySet) Blocks(newCipher func(key []byte) (cipher.Block, error)) (b1, b2, b3 cipher.Block, err error) {
+	if b1, err = newCipher(ks.K1); err != nil {
+		return
+	}
+	if b2, err = newCipher(ks.K2); err != nil {
+		return
+	}
+	b3, err = newCipher(ks.K3)
+	return
+}
+
+// NewEncrypter returns a CBC3 encrypter over the stage ciphers built with
+// newCipher.  When iv is nil the derived triple IV is used.
+func (ks *KeySet) NewEncrypter(newCipher func(key []byte) (cipher.Block, error), iv []byte) (cipher.BlockMode, error) {
+	b1, b2, b3, err := ks.blocksIV(newCipher, &iv)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncrypter(b1, b2, b3, iv), nil
+}
+
+// NewDecrypter returns a CBC3 decrypter over the stage ciphers built with
+// newCipher.  When iv is nil the derived triple IV is used.
+func (ks *KeySet) NewDecrypter(newCipher func(key []byte) (cipher.Block, error), iv []byte) (cipher.BlockMode, error) {
+	b1, b2, b3, err := ks.blocksIV(newCipher, &iv)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecrypter(b1, b2, b3, iv), nil
+}
+
+func (ks *KeySet) blocksIV(newCipher func(key []byte) (cipher.Block, error), iv *[]byte) (b1, b2, b3 cipher.Block, err error) {
+	if b1, b2, b3, err = ks.Blocks(newCipher); err != nil {
+		return
+	}
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		err = errBlockSizes
+		return
+	}
+	if *iv == nil {
+		*iv = ks.IV
+	}
+	if len(*iv) != 3*bs {
+		err = errors.New("cbc3: IV length must equal three times the cipher block size")
+	}
+	return
+}
+
+// Limits on the KDF parameters accepted by ContainerKey.  A reader derives
+// the key before the header MAC can be checked, so the parameters are
+// untrusted and must not be able to demand unbounded time or memory.  Each
+// limit is well above the defaults of cmd/cbc3.
+const (
+	maxPBKDF2Iterations = 1 << 24
+	maxArgon2Time       = 64
+	maxArgon2Memory     = 1 << 20 // KiB, 1 GiB
+	maxScryptN          = 1 << 20
+	maxScryptR          = 32
+	maxScryptP          = 16
+	maxScryptMemory     = 1 << 30 // bytes, 128 * N * r
+)
+
+var errKDFParams = errors.New("cbc3: container key derivation parameters out of range")
+
+// check rejects parameters which would make the KDF panic or exceed the
+// limits above.
+func (p *KDFParams) check() error {
+	switch p.KDF {
+	case KDFPBKDF2:
+		if p.Iterations == 0 || p.Iterations > maxPBKDF2Iterations {
+			return errKDFParams
+		}
+	case KDFScrypt:
+		n, r, par := uint64(p.Iterations), uint64(p.Memory), uint64(p.Parallelism)
+		if n < 2 || n&(n-1) != 0 || n > maxScryptN || r == 0 || r > maxScryptR ||
+			par == 0 || par > maxScryptP || 128*n*r > maxScryptMemory {
+			return errKDFParams
+		}
+	case KDFArgon2id:
+		if p.Iterations == 0 || p.Iterations > maxArgon2Time || p.Parallelism == 0 ||
+			p.Memory == 0 || p.Memory > maxArgon2Memory {
+			return errKDFParams
+		}
+	}
+	return nil
+}
+
+// ContainerKey derives the container key material, a 32 byte MAC key followed
+// by the three stage keys of suite, from password using the recorded KDF
+// parameters, which must lie within fixed limits.  It is meant to be called
+// from the key callback of NewContainerReader, and to produce the key for
+// NewContainerWriter.
+func (p *KDFParams) ContainerKey(password []byte, suite Suite) ([]byte, error) {
+	size := KeySetSize{KeySize: suite.KeySize(), MACSize: containerMACSize}
+	if size.KeySize == 0 {
+		return nil, errors.New("cbc3: unknown suite")
+	}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	var ks *KeySet
+	var err error
+	switch p.KDF {
+	case KDFPBKDF2:
+		ks, err = DeriveKeySetPBKDF2(password, p.Salt, int(p.Iterations), size)
+	case KDFScrypt:
+		ks, err = DeriveKeySetScrypt(password, p.Salt, int(p.Iterations), int(p.Memory), int(p.Parallelism), size)
+	case KDFArgon2id:
+		ks, err = DeriveKeySetArgon2id(password, p.Salt, p.Iterations, p.Memory, p.Parallelism, size)
+	case KDFHKDF:
+		ks, err = DeriveKeySetHKDF(password, p.Salt, []byte("cbc3 container"), size)
+	default:
+		return nil, errors.New("cbc3: container records no key derivation function")
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := append([]byte{}, ks.MACKey...)
+	key = append(key, ks.K1...)
+	key = append(key, ks.K2...)
+	return append(key, ks.K3...), nil
+}
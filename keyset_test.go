@@ -0,0 +1,200 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/des"
+	"io/ioutil"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestSSH1KeySet(t *testing.T) {
+	ks := cbc3.SSH1KeySet([]byte("testit"))
+	mode, err := ks.NewDecrypter(des.NewCipher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := SSH1encrypted[195:]
+	out := make([]byte, len(encrypted))
+	mode.CryptBlocks(out, encrypted)
+	if !bytes.Equal(out[4:], SSH1unencrypted[195:][4:]) {
+		t.Errorf("SSH-1 key set failed to decrypt the fixture")
+	}
+}
+
+func TestDeriveKeySet(t *testing.T) {
+	size := cbc3.KeySetSize{KeySize: 16, BlockSize: 16, MACSize: 32}
+	password, salt := []byte("correct horse"), []byte("0123456789abcdef")
+	derive := map[string]func() (*cbc3.KeySet, error){
+		"PBKDF2": func() (*cbc3.KeySet, error) { return cbc3.DeriveKeySetPBKDF2(password, salt, 1000, size) },
+		"scrypt": func() (*cbc3.KeySet, error) { return cbc3.DeriveKeySetScrypt(password, salt, 1024, 8, 1, size) },
+		"Argon2id": func() (*cbc3.KeySet, error) {
+			return cbc3.DeriveKeySetArgon2id(password, salt, 1, 1024, 1, size)
+		},
+		"HKDF": func() (*cbc3.KeySet, error) { return cbc3.DeriveKeySetHKDF(password, salt, nil, size) },
+	}
+	seen := map[string]string{}
+	for name, f := range derive {
+		ks, err := f()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		again, _ := f()
+		if !bytes.Equal(ks.K1, again.K1) || !bytes.Equal(ks.IV, again.IV) || !bytes.Equal(ks.MACKey, again.MACKey) {
+			t.Errorf("%s: derivation is not deterministic", name)
+		}
+		if len(ks.K1) != 16 || len(ks.K2) != 16 || len(ks.K3) != 16 || len(ks.IV) != 48 || len(ks.MACKey) != 32 {
+			t.Errorf("%s: wrong key material lengths", name)
+		}
+		for _, k := range [][]byte{ks.K1, ks.K2, ks.K3, ks.IV[:16], ks.MACKey[:16]} {
+			if prev, ok := seen[string(k)]; ok {
+				t.Errorf("%s: key material repeats material from %s", name, prev)
+			}
+			seen[string(k)] = name
+		}
+
+		enc, err := ks.NewEncrypter(aes.NewCipher, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		dec, _ := ks.NewDecrypter(aes.NewCipher, nil)
+		buf := []byte("exampleplaintext")
+		enc.CryptBlocks(buf, buf)
+		dec.CryptBlocks(buf, buf)
+		if string(buf) != "exampleplaintext" {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+
+	noIV, _ := cbc3.DeriveKeySetPBKDF2(password, salt, 1000, cbc3.KeySetSize{KeySize: 8})
+	if noIV.IV != nil || noIV.MACKey != nil {
+		t.Errorf("optional material was derived without being asked for")
+	}
+}
+
+func TestContainerKeyDerivation(t *testing.T) {
+	password := []byte("testit")
+	h := &cbc3.Header{
+		Suite: cbc3.SuiteAES256,
+		KDF:   cbc3.KDFParams{KDF: cbc3.KDFScrypt, Salt: []byte("salt"), Iterations: 1024, Memory: 8, Parallelism: 1},
+	}
+	key, err := h.KDF.ContainerKey(password, h.Suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := cbc3.NewContainerWriter(&buf, h, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("derived"))
+	w.Close()
+
+	r, _, err := cbc3.NewContainerReader(&buf, func(h *cbc3.Header) ([]byte, error) {
+		return h.KDF.ContainerKey(password, h.Suite)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ioutil.ReadAll(r); err != nil || string(out) != "derived" {
+		t.Errorf("container round trip failed: %q %v", out, err)
+	}
+}
+
+func TestContainerKeyLimits(t *testing.T) {
+	// The parameters come from a header that is not yet authenticated, so
+	// bad ones must give an error before any work is done.
+	salt := []byte("salt")
+	for _, p := range []cbc3.KDFParams{
+		{KDF: cbc3.KDFPBKDF2, Salt: salt},
+		{KDF: cbc3.KDFPBKDF2, Salt: salt, Iterations: 1 << 31},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 1000, Memory: 8, Parallelism: 1},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 0, Memory: 8, Parallelism: 1},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 1 << 30, Memory: 8, Parallelism: 1},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 1 << 20, Memory: 32, Parallelism: 1},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 1024, Memory: 1 << 20, Parallelism: 1},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 1024, Memory: 8, Parallelism: 255},
+		{KDF: cbc3.KDFScrypt, Salt: salt, Iterations: 1024, Memory: 8},
+		{KDF: cbc3.KDFArgon2id, Salt: salt, Iterations: 0, Memory: 64, Parallelism: 1},
+		{KDF: cbc3.KDFArgon2id, Salt: salt, Iterations: 1, Memory: 64, Parallelism: 0},
+		{KDF: cbc3.KDFArgon2id, Salt: salt, Iterations: 1, Memory: 1 << 31, Parallelism: 1},
+		{KDF: cbc3.KDFArgon2id, Salt: salt, Iterations: 1 << 20, Memory: 64, Parallelism: 1},
+	} {
+		h := &cbc3.Header{Suite: cbc3.SuiteAES128, KDF: p}
+		var buf bytes.Buffer
+		w, err := cbc3.NewContainerWriter(&buf, h, make([]byte, 32+3*16))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		_, _, err = cbc3.NewContainerReader(&buf, func(h *cbc3.Header) ([]byte, error) {
+			return h.KDF.ContainerKey([]byte("testit"), h.Suite)
+		})
+		if err == nil {
+			t.Errorf("%+v accepted", p)
+		}
+	}
+
+	if _, err := cbc3.DeriveKeySetArgon2id([]byte("testit"), salt, 0, 64, 1, cbc3.KeySetSize{KeySize: 16}); err == nil {
+		t.Errorf("Argon2id with zero time accepted")
+	}
+	ok := cbc3.KDFParams{KDF: cbc3.KDFArgon2id, Salt: salt, Iterations: 1, Memory: 64, Parallelism: 1}
+	if _, err := ok.ContainerKey([]byte("testit"), cbc3.SuiteAES128); err != nil {
+		t.Errorf("valid parameters rejected: %v", err)
+	}
+}
+
+func TestKeySetNext(t *testing.T) {
+	ks, err := cbc3.DeriveKeySetHKDF([]byte("stream secret"), nil, nil, cbc3.KeySetSize{KeySize: 16, BlockSize: 16, MACSize: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := ks.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.IV != nil || len(next.K1) != 16 || len(next.MACKey) != 32 {
+		t.Fatalf("next key set has IV %x and key lengths %d, %d", next.IV, len(next.K1), len(next.MACKey))
+	}
+	if bytes.Equal(next.K1, ks.K1) || bytes.Equal(next.K1, next.K2) || bytes.Equal(next.K2, next.K3) {
+		t.Errorf("next keys repeat")
+	}
+	if again, _ := ks.Next(); !bytes.Equal(again.K3, next.K3) || !bytes.Equal(again.MACKey, next.MACKey) {
+		t.Errorf("Next is not deterministic")
+	}
+
+	// A stream rekeyed at the same point on both sides still decrypts.
+	msg := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	enc, _ := ks.NewEncrypter(aes.NewCipher, nil)
+	dec, _ := ks.NewDecrypter(aes.NewCipher, nil)
+	ct := make([]byte, len(msg))
+	enc.CryptBlocks(ct[:32], msg[:32])
+	if err := next.Rekey(enc.(cbc3.Mode), aes.NewCipher); err != nil {
+		t.Fatal(err)
+	}
+	enc.CryptBlocks(ct[32:], msg[32:])
+	pt := make([]byte, len(ct))
+	dec.CryptBlocks(pt[:32], ct[:32])
+	if err := next.Rekey(dec.(cbc3.Mode), aes.NewCipher); err != nil {
+		t.Fatal(err)
+	}
+	dec.CryptBlocks(pt[32:], ct[32:])
+	if !bytes.Equal(pt, msg) {
+		t.Errorf("rekeyed stream did not decrypt")
+	}
+
+	des8 := &cbc3.KeySet{K1: make([]byte, 8), K2: make([]byte, 8), K3: make([]byte, 8)}
+	if err := des8.Rekey(enc.(cbc3.Mode), des.NewCipher); err == nil {
+		t.Errorf("rekey to another block size succeeded")
+	}
+
+	// Lengths past what HKDF can expand, and past the 2 byte length prefix,
+	// are errors rather than panics or silent wrapping.
+	for _, n := range []int{8161, 65536} {
+		if _, err := (&cbc3.KeySet{K1: make([]byte, n), K2: make([]byte, 8), K3: make([]byte, 8)}).Next(); err == nil {
+			t.Errorf("key of %d bytes accepted", n)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Locked memory for key material and chaining state.  On Linux a buffer comes
+// from memfd_secret where the kernel and architecture allow it, which also
+// removes the pages from the kernel's own mapping, and otherwise from an
+// anonymous mapping that is mlocked and left out of core dumps.  Either way
+// the buffer sits between two inaccessible guard pages, its end against the
+// upper one so that an overrun faults at once.  Elsewhere the buffer is
+// ordinary heap memory and Locked reports false; Destroy still zeroes it.
+//
+// Only the bytes in the buffer are protected.  Block ciphers made from a key
+// keep their own expanded copy on the heap.
+
+package cbc3
+
+import "errors"
+
+var errDestroyed = errors.New("cbc3: locked buffer already destroyed")
+
+// LockedBuffer is a fixed size buffer kept out of swap.  It is not garbage
+// collected, since slices of it may outlive it, and must be released with
+// Destroy.
+type LockedBuffer struct {
+	b       []byte // the usable bytes
+	mapping []byte // the whole mapping with guard pages, nil on the heap
+	secret  bool
+}
+
+// NewLockedBuffer returns a zeroed buffer of n bytes.  On Linux it fails if
+// the memory cannot be locked, for example because RLIMIT_MEMLOCK is too low.
+func NewLockedBuffer(n int) (*LockedBuffer, error) {
+	if n <= 0 {
+		return nil, errors.New("cbc3: locked buffer size must be positive")
+	}
+	return allocLocked(n)
+}
+
+// Bytes returns the buffer, which stays valid until Destroy.
+func (l *LockedBuffer) Bytes() []byte { return l.b }
+
+// Locked reports whether the buffer is in locked memory rather than on the
+// heap.
+func (l *LockedBuffer) Locked() bool { return l.mapping != nil }
+
+// Secret reports whether the buffer comes from memfd_secret.
+func (l *LockedBuffer) Secret() bool { return l.secret }
+
+// Destroy zeroes the buffer and releases its memory.  The buffer and any
+// slices of it must not be used afterwards.
+func (l *LockedBuffer) Destroy() error {
+	if l.b == nil {
+		return errDestroyed
+	}
+	for i := range l.b {
+		l.b[i] = 0
+	}
+	l.b = nil
+	if l.mapping == nil {
+		return nil
+	}
+	err := freeLocked(l.mapping)
+	l.mapping = nil
+	return err
+}
+
+// lockSlices moves the contents of the slices into one new locked buffer and
+// points them at their copies.  Nil slices stay nil.
+func lockSlices(ps ...*[]byte) (*LockedBuffer, error) {
+	n := 0
+	for _, p := range ps {
+		n += len(*p)
+	}
+	l, err := NewLockedBuffer(n)
+	if err != nil {
+		return nil, err
+	}
+	// The old slices are only zeroed once all are copied, in case they
+	// share memory.
+	b := l.Bytes()
+	olds := make([][]byte, len(ps))
+	for i, p := range ps {
+		if *p == nil {
+			continue
+		}
+		olds[i] = *p
+		*p = b[:len(olds[i]):len(olds[i])]
+		copy(*p, olds[i])
+		b = b[len(olds[i]):]
+	}
+	for _, old := range olds {
+		for i := range old {
+			old[i] = 0
+		}
+	}
+	return l, nil
+}
+
+// LockMemory moves the IV registers and working block into a LockedBuffer.
+func (x *Encrypter) LockMemory() error { return (*cbc)(x).lockMemory() }
+
+// Destroy zeroes the IV registers and releases them if locked.  The mode
+// must not be used afterwards.
+func (x *Encrypter) Destroy() error { return (*cbc)(x).destroy() }
+
+// LockMemory moves the IV registers and working block into a LockedBuffer.
+func (x *Decrypter) LockMemory() error { return (*cbc)(x).lockMemory() }
+
+// Destroy zeroes the IV registers and releases them if locked.  The mode
+// must not be used afterwards.
+func (x *Decrypter) Destroy() error { return (*cbc)(x).destroy() }
+
+func (x *cbc) lockMemory() error {
+	if x.locked != nil {
+		return nil
+	}
+	l, err := lockSlices(&x.iv, &x.tmp)
+	if err != nil {
+		return err
+	}
+	x.locked = l
+	return nil
+}
+
+func (x *cbc) destroy() error {
+	if x.locked != nil {
+		err := x.locked.Destroy()
+		x.locked, x.iv, x.tmp = nil, nil, nil
+		return err
+	}
+	for _, b := range [][]byte{x.iv, x.tmp} {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+	x.iv, x.tmp = nil, nil
+	return nil
+}
+
+// Lock moves the key material of ks into a LockedBuffer.  Slices taken from
+// ks before the call keep the old, now zeroed, memory.
+func (ks *KeySet) Lock() error {
+	if ks.locked != nil {
+		return nil
+	}
+	l, err := lockSlices(&ks.K1, &ks.K2, &ks.K3, &ks.IV, &ks.MACKey)
+	if err != nil {
+		return err
+	}
+	ks.locked = l
+	return nil
+}
+
+// Destroy zeroes the key material of ks and releases it if locked.
+func (ks *KeySet) Destroy() error {
+	var err error
+	if ks.locked != nil {
+		err = ks.locked.Destroy()
+		ks.locked = nil
+	} else {
+		for _, b := range [][]byte{ks.K1, ks.K2, ks.K3, ks.IV, ks.MACKey} {
+			for i := range b {
+				b[i] = 0
+			}
+		}
+	}
+	ks.K1, ks.K2, ks.K3, ks.IV, ks.MACKey = nil, nil, nil, nil, nil
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package cbc3
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func allocLocked(n int) (*LockedBuffer, error) {
+	page := os.Getpagesize()
+	size := (n + page - 1) / page * page
+	total := size + 2*page
+
+	// mapSecret fails on kernels or architectures without memfd_secret,
+	// and the mapping then falls back to mmap and mlock.
+	l := &LockedBuffer{}
+	mapping, err := mapSecret(total)
+	if err == nil {
+		l.secret = true
+	} else {
+		if mapping, err = unix.Mmap(-1, 0, total, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS); err != nil {
+			return nil, os.NewSyscallError("mmap", err)
+		}
+		if err = unix.Mlock(mapping[page : page+size]); err != nil {
+			unix.Munmap(mapping)
+			return nil, os.NewSyscallError("mlock", err)
+		}
+		// Best effort: older kernels lack these.
+		unix.Madvise(mapping[page:page+size], unix.MADV_DONTDUMP)
+		unix.Madvise(mapping[page:page+size], unix.MADV_WIPEONFORK)
+	}
+
+	if err = unix.Mprotect(mapping[:page], unix.PROT_NONE); err == nil {
+		err = unix.Mprotect(mapping[page+size:], unix.PROT_NONE)
+	}
+	if err != nil {
+		unix.Munmap(mapping)
+		return nil, os.NewSyscallError("mprotect", err)
+	}
+	l.mapping = mapping
+	l.b = mapping[page+size-n : page+size : page+size]
+	return l, nil
+}
+
+func freeLocked(mapping []byte) error {
+	// Unmapping drops the lock; guard pages go with the rest.
+	if err := unix.Munmap(mapping); err != nil {
+		return os.NewSyscallError("munmap", err)
+	}
+	return nil
+}
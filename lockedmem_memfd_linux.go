@@ -0,0 +1,36 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (386 || amd64 || arm64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm64 riscv64 s390x
+
+package cbc3
+
+import "golang.org/x/sys/unix"
+
+// mapSecret maps total bytes of memfd_secret memory, which the kernel keeps
+// locked and out of its direct map.  It fails where the kernel does not
+// offer it, or secretmem is not enabled.
+func mapSecret(total int) ([]byte, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_MEMFD_SECRET, unix.O_CLOEXEC, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	defer unix.Close(int(fd))
+	if err := unix.Ftruncate(int(fd), int64(total)); err != nil {
+		return nil, err
+	}
+	return unix.Mmap(int(fd), 0, total, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
@@ -0,0 +1,24 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && !(386 || amd64 || arm64 || riscv64 || s390x)
+// +build linux,!386,!amd64,!arm64,!riscv64,!s390x
+
+package cbc3
+
+import "golang.org/x/sys/unix"
+
+// mapSecret always fails here, as golang.org/x/sys/unix has no memfd_secret
+// number for this architecture; allocLocked uses mmap and mlock instead.
+func mapSecret(total int) ([]byte, error) { return nil, unix.ENOSYS }
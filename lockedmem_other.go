@@ -0,0 +1,25 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package cbc3
+
+// allocLocked falls back to the heap; Locked reports false.
+func allocLocked(n int) (*LockedBuffer, error) {
+	return &LockedBuffer{b: make([]byte, n)}, nil
+}
+
+func freeLocked(mapping []byte) error { return nil }
@@ -0,0 +1,114 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/des"
+	"runtime"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func newLocked(t *testing.T, n int) *cbc3.LockedBuffer {
+	l, err := cbc3.NewLockedBuffer(n)
+	if err != nil {
+		// RLIMIT_MEMLOCK may be too low in a sandbox.
+		t.Skipf("cannot lock memory: %v", err)
+	}
+	return l
+}
+
+func TestLockedBuffer(t *testing.T) {
+	l := newLocked(t, 100)
+	if runtime.GOOS == "linux" && !l.Locked() {
+		t.Errorf("buffer not locked on linux")
+	}
+	b := l.Bytes()
+	if len(b) != 100 || cap(b) != 100 || !bytes.Equal(b, make([]byte, 100)) {
+		t.Fatalf("buffer of length %d, capacity %d, not zeroed", len(b), cap(b))
+	}
+	copy(b, "secret")
+	if err := l.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Bytes() != nil || l.Locked() {
+		t.Errorf("buffer still usable after Destroy")
+	}
+	if err := l.Destroy(); err == nil {
+		t.Errorf("second Destroy succeeded")
+	}
+
+	if _, err := cbc3.NewLockedBuffer(0); err == nil {
+		t.Errorf("empty buffer allowed")
+	}
+}
+
+func TestModeLockMemory(t *testing.T) {
+	newLocked(t, 1).Destroy()
+	b1, _ := des.NewCipher([]byte("key one."))
+	b2, _ := des.NewCipher([]byte("key two."))
+	b3, _ := des.NewCipher([]byte("keythree"))
+	iv := []byte("the twenty-four byte iv.")
+	msg := []byte("sixteen byte msgsixteen byte msg")
+
+	want := make([]byte, len(msg))
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, msg)
+
+	enc := cbc3.NewEncrypter(b1, b2, b3, iv).(*cbc3.Encrypter)
+	dec := cbc3.NewDecrypter(b1, b2, b3, iv).(*cbc3.Decrypter)
+	for _, err := range []error{enc.LockMemory(), dec.LockMemory(), enc.LockMemory()} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ct := make([]byte, len(msg))
+	enc.CryptBlocks(ct, msg)
+	if !bytes.Equal(ct, want) {
+		t.Errorf("locked encrypter gave %x, want %x", ct, want)
+	}
+	dec.CryptBlocks(ct, ct)
+	if !bytes.Equal(ct, msg) {
+		t.Errorf("locked decrypter failed")
+	}
+	if err := enc.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeySetLock(t *testing.T) {
+	newLocked(t, 1).Destroy()
+	ks := cbc3.SSH1KeySet([]byte("testit"))
+	want := *ks
+	want.K1 = append([]byte(nil), ks.K1...)
+	want.IV = append([]byte(nil), ks.IV...)
+	old := ks.K1
+	if err := ks.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ks.K1, want.K1) || !bytes.Equal(ks.IV, want.IV) || ks.MACKey != nil {
+		t.Errorf("key material changed by Lock")
+	}
+	if !bytes.Equal(old, make([]byte, len(old))) {
+		t.Errorf("old key memory not zeroed")
+	}
+
+	mode, err := ks.NewDecrypter(des.NewCipher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(SSH1encrypted)-195)
+	mode.CryptBlocks(out, SSH1encrypted[195:])
+	if !bytes.Equal(out[4:], SSH1unencrypted[195+4:]) {
+		t.Errorf("locked key set failed to decrypt the fixture")
+	}
+
+	if err := ks.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if ks.K1 != nil || ks.IV != nil {
+		t.Errorf("key set still holds material after Destroy")
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// CBC-MAC algorithms of ISO/IEC 9797-1.  The data is padded, run through CBC
+// with a zero IV, and the last block H goes through an output transformation:
+//
+//   MAC algorithm 1: G = H                 (CBC-MAC, FIPS 113, ANSI X9.9)
+//   MAC algorithm 2: G = E_K'(H)
+//   MAC algorithm 3: G = E_K(D_K'(H))      (retail MAC, ANSI X9.19)
+//
+// The CBC3 MAC runs the data through the CBC3 encrypter instead, so all three
+// layers of chaining feed into the result, and outputs the last block.  CMAC
+// (NIST SP 800-38B) is here too as it is the basis of AES key check values.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"hash"
+)
+
+// MACPadding selects an ISO/IEC 9797-1 padding method.
+type MACPadding int
+
+const (
+	// MACPadding1 appends zero bytes up to the block boundary, and nothing
+	// if the data is already full blocks.  Empty data becomes one block of
+	// zeros.
+	MACPadding1 MACPadding = 1
+
+	// MACPadding2 appends a 0x80 byte and then zero bytes up to the block
+	// boundary.
+	MACPadding2 MACPadding = 2
+
+	// MACPadding3 prefixes a block holding the bit length of the data and
+	// then pads as method 1, except that empty data is left empty.  As the
+	// length comes first, the whole message is buffered until Sum.
+	MACPadding3 MACPadding = 3
+)
+
+// macChain is the chaining state of a CBC-MAC.  CryptBlocks absorbs whole
+// blocks, writing the chaining output to dst.
+type macChain interface {
+	cipher.BlockMode
+	clone() macChain
+}
+
+type cbcChain struct {
+	b cipher.Block
+	x []byte
+}
+
+func (c *cbcChain) BlockSize() int { return c.b.BlockSize() }
+
+func (c *cbcChain) CryptBlocks(dst, src []byte) {
+	bs := len(c.x)
+	for len(src) > 0 {
+		xorBytes(c.x, c.x, src[:bs])
+		c.b.Encrypt(c.x, c.x)
+		copy(dst, c.x)
+		src, dst = src[bs:], dst[bs:]
+	}
+}
+
+func (c *cbcChain) clone() macChain {
+	return &cbcChain{b: c.b, x: dup(c.x)}
+}
+
+func (x *Encrypter) clone() macChain {
+	y := *x
+	y.iv = dup(x.iv)
+	y.tmp = make([]byte, len(x.tmp))
+	y.locked = nil
+	return &y
+}
+
+type cbcMAC struct {
+	newChain func() macChain
+	output   func(h []byte)
+	pad      MACPadding
+
+	chain macChain
+	bs    int
+	buf   []byte // pending partial block, or the whole message for method 3
+	last  []byte // last chaining output
+	n     uint64
+}
+
+func newCBCMAC(newChain func() macChain, output func(h []byte), pad MACPadding) hash.Hash {
+	if pad < MACPadding1 || pad > MACPadding3 {
+		panic("cbc3: unknown ISO/IEC 9797-1 padding method")
+	}
+	m := &cbcMAC{newChain: newChain, output: output, pad: pad}
+	m.Reset()
+	return m
+}
+
+// NewCBCMAC returns ISO/IEC 9797-1 MAC algorithm 1, the plain CBC-MAC of
+// FIPS 113 and ANSI X9.9, over b.
+func NewCBCMAC(b cipher.Block, pad MACPadding) hash.Hash {
+	return newCBCMAC(func() macChain {
+		return &cbcChain{b: b, x: make([]byte, b.BlockSize())}
+	}, nil, pad)
+}
+
+// NewCBCMACFinal returns ISO/IEC 9797-1 MAC algorithm 2: the CBC-MAC over b
+// with the last block encrypted once more under b2.
+func NewCBCMACFinal(b, b2 cipher.Block, pad MACPadding) hash.Hash {
+	if b.BlockSize() != b2.BlockSize() {
+		panic("cbc3.NewCBCMACFinal: BlockSize must be equal for both block ciphers")
+	}
+	return newCBCMAC(func() macChain {
+		return &cbcChain{b: b, x: make([]byte, b.BlockSize())}
+	}, func(h []byte) {
+		b2.Encrypt(h, h)
+	}, pad)
+}
+
+// NewRetailMAC returns ISO/IEC 9797-1 MAC algorithm 3, the ANSI X9.19 retail
+// MAC: the CBC-MAC over b with the last block decrypted under b2 and encrypted
+// again under b.  With DES for b and b2 the last block is effectively
+// encrypted with two key triple DES.
+func NewRetailMAC(b, b2 cipher.Block, pad MACPadding) hash.Hash {
+	if b.BlockSize() != b2.BlockSize() {
+		panic("cbc3.NewRetailMAC: BlockSize must be equal for both block ciphers")
+	}
+	return newCBCMAC(func() macChain {
+		return &cbcChain{b: b, x: make([]byte, b.BlockSize())}
+	}, func(h []byte) {
+		b2.Decrypt(h, h)
+		b.Encrypt(h, h)
+	}, pad)
+}
+
+// NewCBC3MAC returns a MAC which runs the padded data through the CBC3
+// encrypter with an all-zero triple IV and outputs the last ciphertext
+// block.
+func NewCBC3MAC(b1, b2, b3 cipher.Block, pad MACPadding) hash.Hash {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		panic("cbc3.NewCBC3MAC: BlockSize must be equal for all three block ciphers")
+	}
+	iv := make([]byte, 3*bs)
+	return newCBCMAC(func() macChain {
+		return (*Encrypter)(newCBC3(b1, b2, b3, iv))
+	}, nil, pad)
+}
+
+func (m *cbcMAC) Size() int { return m.bs }
+
+func (m *cbcMAC) BlockSize() int { return m.bs }
+
+func (m *cbcMAC) Reset() {
+	m.chain = m.newChain()
+	m.bs = m.chain.BlockSize()
+	m.buf = m.buf[:0]
+	m.last = make([]byte, m.bs)
+	m.n = 0
+}
+
+func (m *cbcMAC) Write(p []byte) (int, error) {
+	m.n += uint64(len(p))
+	if m.pad == MACPadding3 {
+		m.buf = append(m.buf, p...)
+		return len(p), nil
+	}
+	n := len(p)
+	if len(m.buf) > 0 {
+		c := copy(m.buf[len(m.buf):m.bs], p)
+		m.buf = m.buf[:len(m.buf)+c]
+		p = p[c:]
+		if len(m.buf) < m.bs {
+			return n, nil
+		}
+		m.absorb(m.chain, m.last, m.buf)
+		m.buf = m.buf[:0]
+	}
+	full := len(p) - len(p)%m.bs
+	m.absorb(m.chain, m.last, p[:full])
+	if m.buf == nil {
+		m.buf = make([]byte, 0, m.bs)
+	}
+	m.buf = append(m.buf, p[full:]...)
+	return n, nil
+}
+
+// absorb feeds whole blocks into chain and leaves the last output in last.
+func (m *cbcMAC) absorb(chain macChain, last, blocks []byte) {
+	for len(blocks) > 0 {
+		chain.CryptBlocks(last, blocks[:m.bs])
+		blocks = blocks[m.bs:]
+	}
+}
+
+func (m *cbcMAC) Sum(in []byte) []byte {
+	chain, last := m.chain.clone(), dup(m.last)
+
+	var tail []byte
+	switch m.pad {
+	case MACPadding1:
+		if len(m.buf) > 0 || m.n == 0 {
+			tail = ZeroPadding.Pad(append([]byte{}, m.buf...), m.bs)
+			if len(tail) == 0 {
+				tail = make([]byte, m.bs)
+			}
+		}
+	case MACPadding2:
+		tail = ISO7816Padding.Pad(append([]byte{}, m.buf...), m.bs)
+	case MACPadding3:
+		tail = make([]byte, m.bs)
+		binary.BigEndian.PutUint64(tail[m.bs-8:], m.n*8)
+		tail = ZeroPadding.Pad(append(tail, m.buf...), m.bs)
+	}
+	m.absorb(chain, last, tail)
+
+	if m.output != nil {
+		m.output(last)
+	}
+	return append(in, last...)
+}
+
+type cmac struct {
+	b      cipher.Block
+	k1, k2 []byte
+	x      []byte
+	buf    []byte
+}
+
+// NewCMAC returns the CMAC of NIST SP 800-38B (RFC 4493 for AES) over b,
+// which must have a 64 or 128 bit block size.
+func NewCMAC(b cipher.Block) hash.Hash {
+	bs := b.BlockSize()
+	var rb byte
+	switch bs {
+	case 8:
+		rb = 0x1b
+	case 16:
+		rb = 0x87
+	default:
+		panic("cbc3.NewCMAC: block size must be 8 or 16 bytes")
+	}
+	dbl := func(dst, src []byte) {
+		carry := src[0] >> 7
+		for i := 0; i < bs-1; i++ {
+			dst[i] = src[i]<<1 | src[i+1]>>7
+		}
+		dst[bs-1] = src[bs-1]<<1 ^ rb&-carry
+	}
+	l := make([]byte, bs)
+	b.Encrypt(l, l)
+	c := &cmac{b: b, k1: make([]byte, bs), k2: make([]byte, bs), x: make([]byte, bs), buf: make([]byte, 0, bs)}
+	dbl(c.k1, l)
+	dbl(c.k2, c.k1)
+	return c
+}
+
+func (c *cmac) Size() int { return len(c.x) }
+
+func (c *cmac) BlockSize() int { return len(c.x) }
+
+func (c *cmac) Reset() {
+	for i := range c.x {
+		c.x[i] = 0
+	}
+	c.buf = c.buf[:0]
+}
+
+// Write keeps the last block, even when full, in buf as it has to be treated
+// specially by Sum.
+func (c *cmac) Write(p []byte) (int, error) {
+	n, bs := len(p), len(c.x)
+	for len(p) > 0 {
+		if len(c.buf) == bs {
+			xorBytes(c.x, c.x, c.buf)
+			c.b.Encrypt(c.x, c.x)
+			c.buf = c.buf[:0]
+		}
+		m := copy(c.buf[len(c.buf):bs], p)
+		c.buf = c.buf[:len(c.buf)+m]
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (c *cmac) Sum(in []byte) []byte {
+	bs := len(c.x)
+	last := make([]byte, bs)
+	copy(last, c.buf)
+	if len(c.buf) == bs {
+		xorBytes(last, last, c.k1)
+	} else {
+		last[len(c.buf)] = 0x80
+		xorBytes(last, last, c.k2)
+	}
+	xorBytes(last, last, c.x)
+	c.b.Encrypt(last, last)
+	return append(in, last...)
+}
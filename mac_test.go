@@ -0,0 +1,127 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/hex"
+	"hash"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestISO9797MACVectors(t *testing.T) {
+	k, _ := des.NewCipher(hexKey("0123456789abcdef"))
+	k2, _ := des.NewCipher(hexKey("fedcba9876543210"))
+	tests := []struct {
+		name string
+		mac  hash.Hash
+		data string
+		want string
+	}{
+		// FIPS 113 appendix, which publishes the leading 32 bits F1D30F68.
+		{"FIPS 113", cbc3.NewCBCMAC(k, cbc3.MACPadding1), "7654321 Now is the time for ", "f1d30f6849312ca4"},
+		// ISO/IEC 9797-1 annex B, MAC algorithm 1 and 3 with padding method 1.
+		{"ISO 9797-1 alg 1", cbc3.NewCBCMAC(k, cbc3.MACPadding1), "Now is the time for all ", "70a30640cc76dd8b"},
+		{"ISO 9797-1 alg 3", cbc3.NewRetailMAC(k, k2, cbc3.MACPadding1), "Now is the time for all ", "a1c72e74ea3fa9b6"},
+	}
+	for _, tc := range tests {
+		tc.mac.Write([]byte(tc.data))
+		if got := hex.EncodeToString(tc.mac.Sum(nil)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// cbcLast returns the last block of the CBC encryption of data with a zero IV.
+func cbcLast(b cipher.Block, data []byte) []byte {
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(b, make([]byte, b.BlockSize())).CryptBlocks(out, data)
+	return out[len(out)-b.BlockSize():]
+}
+
+func TestISO9797MACAlgorithms(t *testing.T) {
+	k, _ := des.NewCipher(hexKey("0123456789abcdef"))
+	k2, _ := des.NewCipher(hexKey("fedcba9876543210"))
+	tdes, _ := des.NewTripleDESCipher(hexKey("0123456789abcdeffedcba98765432100123456789abcdef"))
+	data := []byte("Now is the time for it")
+	pad1 := append(append([]byte{}, data...), 0, 0)
+	pad2 := append(append([]byte{}, data...), 0x80, 0)
+	pad3 := append(hexKey("00000000000000b0"), pad1...)
+
+	for _, tc := range []struct {
+		pad    cbc3.MACPadding
+		padded []byte
+	}{{cbc3.MACPadding1, pad1}, {cbc3.MACPadding2, pad2}, {cbc3.MACPadding3, pad3}} {
+		h := cbcLast(k, tc.padded)
+
+		alg1 := cbc3.NewCBCMAC(k, tc.pad)
+		alg1.Write(data)
+		if got := alg1.Sum(nil); !bytes.Equal(got, h) {
+			t.Errorf("padding %d: algorithm 1 got %x, want %x", tc.pad, got, h)
+		}
+
+		want := make([]byte, 8)
+		k2.Encrypt(want, h)
+		alg2 := cbc3.NewCBCMACFinal(k, k2, tc.pad)
+		alg2.Write(data)
+		if got := alg2.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("padding %d: algorithm 2 got %x, want %x", tc.pad, got, want)
+		}
+
+		// The retail MAC equals two key triple DES on the last block after
+		// it is chained with the single DES CBC-MAC of the blocks before it.
+		lastBlock := tc.padded[len(tc.padded)-8:]
+		chained := cbcLast(k, tc.padded[:len(tc.padded)-8])
+		for i := range chained {
+			chained[i] ^= lastBlock[i]
+		}
+		tdes.Encrypt(want, chained)
+		alg3 := cbc3.NewRetailMAC(k, k2, tc.pad)
+		alg3.Write(data)
+		if got := alg3.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("padding %d: algorithm 3 got %x, want %x", tc.pad, got, want)
+		}
+	}
+}
+
+func TestCBC3MAC(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	data := []byte("exampleplaintext, streamed in odd pieces")
+
+	padded := cbc3.ISO7816Padding.Pad(append([]byte{}, data...), 8)
+	ct := make([]byte, len(padded))
+	cbc3.NewEncrypter(b1, b2, b3, make([]byte, 24)).CryptBlocks(ct, padded)
+	want := ct[len(ct)-8:]
+
+	mac := cbc3.NewCBC3MAC(b1, b2, b3, cbc3.MACPadding2)
+	for i := 0; i < len(data); i += 3 {
+		end := i + 3
+		if end > len(data) {
+			end = len(data)
+		}
+		mac.Write(data[i:end])
+		mac.Sum(nil) // Sum must not disturb the running state.
+	}
+	if got := mac.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	mac.Reset()
+	mac.Write(data)
+	if got := mac.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("after Reset got %x, want %x", got, want)
+	}
+}
+
+func TestCBCMACEmpty(t *testing.T) {
+	k, _ := des.NewCipher(hexKey("0123456789abcdef"))
+	want := make([]byte, 8)
+	k.Encrypt(want, want)
+	if got := cbc3.NewCBCMAC(k, cbc3.MACPadding1).Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("empty data with padding method 1: got %x, want %x", got, want)
+	}
+}
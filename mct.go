@@ -0,0 +1,153 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Monte Carlo testing in the style of the NIST SP 800-20 TCBC test, adapted to
+// three stage keys and a triple IV.  Each outer round builds a mode from the
+// current keys and IV and runs the inner loop through it without resetting
+// the chaining state:
+//
+//   I[0] = input, I[1] = IV register 1, I[j+1] = O[j-1] for j > 0
+//   O[j] = CryptBlocks(I[j])
+//
+// After the inner loop of n steps stage key s (0, 1, 2) is XORed with the
+// last len(key) bytes of ... || O[n-2-s] || O[n-1-s], which for keys of one
+// block is O[n-1-s] as in SP 800-20.  The next round starts from the IV
+// O[n-1] || O[n-2] || O[n-3] and the input O[n-2].  Decryption tests run the
+// same iteration through the decrypter.  There are no published results for
+// CBC3, so the tests pin values computed with NewReferenceEncrypter and
+// NewReferenceDecrypter.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"errors"
+)
+
+// MCT configures a Monte Carlo test.
+type MCT struct {
+	// NewCipher builds the stage ciphers from the keys.
+	NewCipher func(key []byte) (cipher.Block, error)
+
+	// Decrypt selects the decryption test.
+	Decrypt bool
+
+	// Outer and Inner are the numbers of rounds and of blocks per round.
+	// Zero means the standard 400 and 10,000.
+	Outer, Inner int
+
+	// NewMode, if set, builds the mode under test in place of NewEncrypter
+	// or NewDecrypter, for running the test on another implementation.
+	NewMode func(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode
+}
+
+// MCTResult records one outer round: the keys, IV and input it started from
+// and the last block it produced.
+type MCTResult struct {
+	Keys   [3][]byte
+	IV     []byte
+	Input  []byte
+	Output []byte
+}
+
+// Run performs the test from the given stage keys, triple IV and one block
+// of input, and returns the record of every outer round.  It returns an error
+// if the IV is not three times the input, the ciphers do not all have the
+// input length as block size, or the inner loop is too short to supply the
+// key update.
+func (m *MCT) Run(keys [3][]byte, iv, input []byte) ([]MCTResult, error) {
+	outer, inner := m.Outer, m.Inner
+	if outer == 0 {
+		outer = 400
+	}
+	if inner == 0 {
+		inner = 10000
+	}
+	newMode := m.NewMode
+	if newMode == nil {
+		newMode = NewEncrypter
+		if m.Decrypt {
+			newMode = NewDecrypter
+		}
+	}
+
+	var k [3][]byte
+	maxKey := 0
+	for i := range keys {
+		k[i] = dup(keys[i])
+		if len(k[i]) > maxKey {
+			maxKey = len(k[i])
+		}
+	}
+	bs := len(input)
+	if len(iv) != 3*bs {
+		return nil, errors.New("cbc3: MCT IV length must equal three times the input length")
+	}
+	// The key update reaches back 2 blocks plus the key length.
+	hist := make([][]byte, 3+(maxKey+bs-1)/bs)
+	if inner < len(hist) {
+		return nil, errors.New("cbc3: too few MCT inner iterations for the key size")
+	}
+	for i := range hist {
+		hist[i] = make([]byte, bs)
+	}
+	out := func(back int) []byte { return hist[(inner-1-back)%len(hist)] }
+
+	iv, in := dup(iv), dup(input)
+	var results []MCTResult
+	for i := 0; i < outer; i++ {
+		var bl [3]cipher.Block
+		for s := range bl {
+			b, err := m.NewCipher(k[s])
+			if err != nil {
+				return nil, err
+			}
+			bl[s] = b
+		}
+		for _, b := range bl {
+			if b.BlockSize() != bs {
+				return nil, errors.New("cbc3: MCT input length must equal the cipher block size")
+			}
+		}
+		r := MCTResult{IV: dup(iv), Input: dup(in)}
+		for s := range k {
+			r.Keys[s] = dup(k[s])
+		}
+
+		mode := newMode(bl[0], bl[1], bl[2], iv)
+		src := in
+		for j := 0; j < inner; j++ {
+			dst := hist[j%len(hist)]
+			mode.CryptBlocks(dst, src)
+			if j == 0 {
+				src = iv[:bs]
+			} else {
+				src = hist[(j-1)%len(hist)]
+			}
+		}
+		r.Output = dup(out(0))
+		results = append(results, r)
+
+		for s := range k {
+			var mat []byte
+			for back := s + (len(k[s])+bs-1)/bs - 1; back >= s; back-- {
+				mat = append(mat, out(back)...)
+			}
+			xorBytes(k[s], k[s], mat[len(mat)-len(k[s]):])
+		}
+		iv = append(append(append(iv[:0], out(0)...), out(1)...), out(2)...)
+		in = dup(out(1))
+	}
+	return results, nil
+}
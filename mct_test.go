@@ -0,0 +1,119 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+// The results of the first and last round of the full 400x10,000 test.  They
+// were computed with the standard library reference implementation; the test
+// also runs it when -short is given.
+var mctTests = []struct {
+	name        string
+	newCipher   func([]byte) (cipher.Block, error)
+	keys        [3]string
+	iv, input   string
+	decrypt     bool
+	first, last string
+}{
+	{"DES encrypt", des.NewCipher,
+		[3]string{"0123456789abcdef", "23456789abcdef01", "456789abcdef0123"},
+		"f69f2445df4f9b17ad2b417be66c37106bc1bee22e409f96", "4e6f772069732074", false,
+		"6ce6ad41a94afbdf", "48acfcbfcd8b7c82"},
+	{"DES decrypt", des.NewCipher,
+		[3]string{"0123456789abcdef", "23456789abcdef01", "456789abcdef0123"},
+		"f69f2445df4f9b17ad2b417be66c37106bc1bee22e409f96", "4e6f772069732074", true,
+		"922ee6909a3d8920", "b7ca6bf33846a81d"},
+	{"AES encrypt", aes.NewCipher,
+		[3]string{"2b7e151628aed2a6abf7158809cf4f3c", "8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b", "603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4"},
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f", "6bc1bee22e409f96e93d7e117393172a", false,
+		"b80ef4659e8258b37a7408bdad3f1e70", "d926fbf984f5a68bd16372dd890524e5"},
+	{"AES decrypt", aes.NewCipher,
+		[3]string{"2b7e151628aed2a6abf7158809cf4f3c", "8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b", "603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4"},
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f", "6bc1bee22e409f96e93d7e117393172a", true,
+		"c9fc23260c0db3dfce5e923e42716993", "01f2a02bed395f3c78a32073bcc03cdc"},
+}
+
+func TestMonteCarlo(t *testing.T) {
+	for _, tc := range mctTests {
+		keys := [3][]byte{hexKey(tc.keys[0]), hexKey(tc.keys[1]), hexKey(tc.keys[2])}
+		mct := &cbc3.MCT{NewCipher: tc.newCipher, Decrypt: tc.decrypt}
+		if testing.Short() {
+			mct.Outer, mct.Inner = 8, 500
+		}
+		got, err := mct.Run(keys, hexKey(tc.iv), hexKey(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !testing.Short() {
+			if len(got) != 400 {
+				t.Fatalf("%s: got %d rounds", tc.name, len(got))
+			}
+			if !bytes.Equal(got[0].Output, hexKey(tc.first)) || !bytes.Equal(got[399].Output, hexKey(tc.last)) {
+				t.Errorf("%s: got %x ... %x, want %s ... %s", tc.name, got[0].Output, got[399].Output, tc.first, tc.last)
+			}
+			continue
+		}
+
+		mct.NewMode = cbc3.NewReferenceEncrypter
+		if tc.decrypt {
+			mct.NewMode = cbc3.NewReferenceDecrypter
+		}
+		want, _ := mct.Run(keys, hexKey(tc.iv), hexKey(tc.input))
+		for i := range want {
+			if !bytes.Equal(got[i].Output, want[i].Output) || !bytes.Equal(got[i].Keys[2], want[i].Keys[2]) {
+				t.Fatalf("%s: round %d differs from the reference", tc.name, i)
+			}
+		}
+	}
+}
+
+func TestMonteCarloChaining(t *testing.T) {
+	// Every round starts from the last outputs of the one before.
+	keys := [3][]byte{hexKey("0123456789abcdef"), hexKey("23456789abcdef01"), hexKey("456789abcdef0123")}
+	mct := &cbc3.MCT{NewCipher: des.NewCipher, Outer: 3, Inner: 10}
+	rs, err := mct.Run(keys, make([]byte, 24), make([]byte, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(rs); i++ {
+		if !bytes.Equal(rs[i].IV[:8], rs[i-1].Output) {
+			t.Errorf("round %d: IV does not start with the last output", i)
+		}
+		for s := range rs[i].Keys {
+			if bytes.Equal(rs[i].Keys[s], rs[i-1].Keys[s]) {
+				t.Errorf("round %d: key %d not updated", i, s+1)
+			}
+		}
+	}
+}
+
+func TestMonteCarloErrors(t *testing.T) {
+	keys := [3][]byte{hexKey("0123456789abcdef"), hexKey("23456789abcdef01"), hexKey("456789abcdef0123")}
+	mixed := func(key []byte) (cipher.Block, error) {
+		if key[0] == 0x45 {
+			return aes.NewCipher(append(dup(key), key...))
+		}
+		return des.NewCipher(key)
+	}
+	for _, tc := range []struct {
+		name      string
+		mct       cbc3.MCT
+		iv, input []byte
+	}{
+		{"short IV", cbc3.MCT{NewCipher: des.NewCipher, Outer: 1}, make([]byte, 16), make([]byte, 8)},
+		{"block size", cbc3.MCT{NewCipher: des.NewCipher, Outer: 1}, make([]byte, 48), make([]byte, 16)},
+		{"mixed block sizes", cbc3.MCT{NewCipher: mixed, Outer: 1}, make([]byte, 24), make([]byte, 8)},
+		{"few iterations", cbc3.MCT{NewCipher: des.NewCipher, Outer: 1, Inner: 3}, make([]byte, 24), make([]byte, 8)},
+	} {
+		if _, err := tc.mct.Run(keys, tc.iv, tc.input); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
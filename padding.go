@@ -0,0 +1,187 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import "errors"
+
+var errPadding = errors.New("cbc3: invalid padding")
+
+// Padding fills out the last partial block of a plaintext before encryption
+// and removes the fill again after decryption.
+type Padding interface {
+	// Pad appends padding to buf so its length becomes a multiple of
+	// blockSize and returns the extended slice.
+	Pad(buf []byte, blockSize int) []byte
+
+	// Unpad returns buf, which must be a multiple of blockSize long, with
+	// the padding removed.
+	Unpad(buf []byte, blockSize int) ([]byte, error)
+}
+
+var (
+	// PKCS7Padding appends n bytes of value n, always adding at least one
+	// byte (RFC 5652 section 6.3).
+	PKCS7Padding Padding = pkcs7Padding{}
+
+	// ANSIX923Padding appends n-1 zero bytes followed by a byte of value n,
+	// always adding at least one byte.
+	ANSIX923Padding Padding = ansiX923Padding{}
+
+	// ISO7816Padding appends a single 0x80 byte followed by zero bytes up to
+	// the block boundary (ISO/IEC 7816-4, ISO/IEC 9797-1 padding method 2).
+	ISO7816Padding Padding = iso7816Padding{}
+
+	// ZeroPadding appends zero bytes up to the block boundary and adds nothing
+	// to an input which is already full blocks (ISO/IEC 9797-1 padding method
+	// 1).  Unpad strips all trailing zero bytes, so it is only reversible for
+	// plaintexts which do not end in zero.
+	ZeroPadding Padding = zeroPadding{}
+
+	// NoPadding leaves the input alone.  The plaintext must already be a
+	// multiple of the block size.
+	NoPadding Padding = noPadding{}
+)
+
+type pkcs7Padding struct{}
+
+func (pkcs7Padding) Pad(buf []byte, blockSize int) []byte {
+	n := len(buf)
+	buf, _ = sliceForAppend(buf, blockSize-n%blockSize)
+	pkcs7Pad(buf[n:])
+	return buf
+}
+
+func (pkcs7Padding) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	return pkcs7Unpad(buf, blockSize)
+}
+
+// pkcs7Pad fills pad with the PKCS#7 padding value, which is its own length.
+func pkcs7Pad(pad []byte) {
+	for i := range pad {
+		pad[i] = byte(len(pad))
+	}
+}
+
+// pkcs7Unpad strips PKCS#7 padding from buf, which must be a non-empty
+// multiple of blockSize long.
+func pkcs7Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if len(buf) == 0 || len(buf)%blockSize != 0 {
+		return nil, errPadding
+	}
+	n := int(buf[len(buf)-1])
+	good := byte(0)
+	if n == 0 || n > blockSize {
+		good = 1
+		n = 1
+	}
+	for _, b := range buf[len(buf)-n:] {
+		good |= b ^ byte(n)
+	}
+	if good != 0 {
+		return nil, errPadding
+	}
+	return buf[:len(buf)-n], nil
+}
+
+type ansiX923Padding struct{}
+
+func (ansiX923Padding) Pad(buf []byte, blockSize int) []byte {
+	n := len(buf)
+	buf, pad := sliceForAppend(buf, blockSize-n%blockSize)
+	for i := range pad {
+		pad[i] = 0
+	}
+	pad[len(pad)-1] = byte(len(pad))
+	return buf
+}
+
+func (ansiX923Padding) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if len(buf) == 0 || len(buf)%blockSize != 0 {
+		return nil, errPadding
+	}
+	n := int(buf[len(buf)-1])
+	if n == 0 || n > blockSize {
+		return nil, errPadding
+	}
+	for _, b := range buf[len(buf)-n : len(buf)-1] {
+		if b != 0 {
+			return nil, errPadding
+		}
+	}
+	return buf[:len(buf)-n], nil
+}
+
+type iso7816Padding struct{}
+
+func (iso7816Padding) Pad(buf []byte, blockSize int) []byte {
+	n := len(buf)
+	buf, pad := sliceForAppend(buf, blockSize-n%blockSize)
+	pad[0] = 0x80
+	for i := 1; i < len(pad); i++ {
+		pad[i] = 0
+	}
+	return buf
+}
+
+func (iso7816Padding) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if len(buf) == 0 || len(buf)%blockSize != 0 {
+		return nil, errPadding
+	}
+	for i := len(buf) - 1; i >= len(buf)-blockSize; i-- {
+		switch buf[i] {
+		case 0:
+		case 0x80:
+			return buf[:i], nil
+		default:
+			return nil, errPadding
+		}
+	}
+	return nil, errPadding
+}
+
+type zeroPadding struct{}
+
+func (zeroPadding) Pad(buf []byte, blockSize int) []byte {
+	if len(buf)%blockSize == 0 {
+		return buf
+	}
+	buf, pad := sliceForAppend(buf, blockSize-len(buf)%blockSize)
+	for i := range pad {
+		pad[i] = 0
+	}
+	return buf
+}
+
+func (zeroPadding) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if len(buf)%blockSize != 0 {
+		return nil, errPadding
+	}
+	n := len(buf)
+	for n > 0 && buf[n-1] == 0 {
+		n--
+	}
+	return buf[:n], nil
+}
+
+type noPadding struct{}
+
+func (noPadding) Pad(buf []byte, blockSize int) []byte { return buf }
+
+func (noPadding) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if len(buf)%blockSize != 0 {
+		return nil, errPadding
+	}
+	return buf, nil
+}
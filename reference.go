@@ -0,0 +1,53 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import "crypto/cipher"
+
+// NewReferenceEncrypter returns a BlockMode which encrypts exactly as
+// NewEncrypter does, but is put together from the standard library: CBC
+// encryption under b1, CBC decryption under b2 and CBC encryption under b3,
+// each with its own register of the triple IV.  It is slow and meant for
+// checking other implementations against.
+func NewReferenceEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	bs := checkReference(b1, b2, b3, iv)
+	return Compose(
+		cipher.NewCBCEncrypter(b1, iv[:bs]),
+		cipher.NewCBCDecrypter(b2, iv[bs:2*bs]),
+		cipher.NewCBCEncrypter(b3, iv[2*bs:]),
+	)
+}
+
+// NewReferenceDecrypter returns the standard library counterpart of
+// NewDecrypter, undoing NewReferenceEncrypter layer by layer.
+func NewReferenceDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	bs := checkReference(b1, b2, b3, iv)
+	return ComposeDecrypter(
+		cipher.NewCBCDecrypter(b1, iv[:bs]),
+		cipher.NewCBCEncrypter(b2, iv[bs:2*bs]),
+		cipher.NewCBCDecrypter(b3, iv[2*bs:]),
+	)
+}
+
+func checkReference(b1, b2, b3 cipher.Block, iv []byte) int {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		panic("cbc3.NewReference: BlockSize must be equal for all three block ciphers")
+	}
+	if len(iv) != 3*bs {
+		panic("cbc3.NewReference: IV length must equal three times the cipher block size")
+	}
+	return bs
+}
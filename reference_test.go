@@ -0,0 +1,96 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"math/rand"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+var diffCiphers = []struct {
+	name      string
+	newCipher func([]byte) (cipher.Block, error)
+	keySize   int
+}{
+	{"DES", des.NewCipher, 8},
+	{"AES-128", aes.NewCipher, 16},
+	{"AES-256", aes.NewCipher, 32},
+}
+
+// fit cuts or zero extends p to n bytes.
+func fit(p []byte, n int) []byte {
+	q := make([]byte, n)
+	copy(q, p)
+	return q
+}
+
+// checkDifferential runs msg through the optimized and the reference modes in
+// both directions, in two calls split after block split, and reports any
+// difference.  key holds the three stage keys back to back.
+func checkDifferential(t testing.TB, newCipher func([]byte) (cipher.Block, error), keySize int, key, iv, msg []byte, split int) {
+	var bl [3]cipher.Block
+	for i := range bl {
+		b, err := newCipher(key[i*keySize : (i+1)*keySize])
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl[i] = b
+	}
+	bs := bl[0].BlockSize()
+	split *= bs
+
+	run := func(mode cipher.BlockMode, src []byte) []byte {
+		dst := make([]byte, len(src))
+		mode.CryptBlocks(dst[:split], src[:split])
+		mode.CryptBlocks(dst[split:], src[split:])
+		return dst
+	}
+	ct := run(cbc3.NewEncrypter(bl[0], bl[1], bl[2], iv), msg)
+	if want := run(cbc3.NewReferenceEncrypter(bl[0], bl[1], bl[2], iv), msg); !bytes.Equal(ct, want) {
+		t.Fatalf("encrypt differs from reference\nkey %x\niv  %x\nmsg %x\ngot  %x\nwant %x", key, iv, msg, ct, want)
+	}
+	pt := run(cbc3.NewDecrypter(bl[0], bl[1], bl[2], iv), ct)
+	if want := run(cbc3.NewReferenceDecrypter(bl[0], bl[1], bl[2], iv), ct); !bytes.Equal(pt, want) {
+		t.Fatalf("decrypt differs from reference\nkey %x\niv  %x\nct  %x\ngot  %x\nwant %x", key, iv, ct, pt, want)
+	}
+	if !bytes.Equal(pt, msg) {
+		t.Fatalf("round trip failed\nkey %x\niv  %x\nmsg %x", key, iv, msg)
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, c := range diffCiphers {
+		bs := 8
+		if c.keySize > 8 {
+			bs = 16
+		}
+		for i := 0; i < 200; i++ {
+			key := make([]byte, 3*c.keySize)
+			iv := make([]byte, 3*bs)
+			msg := make([]byte, bs*rnd.Intn(40))
+			rnd.Read(key)
+			rnd.Read(iv)
+			rnd.Read(msg)
+			checkDifferential(t, c.newCipher, c.keySize, key, iv, msg, rnd.Intn(len(msg)/bs+1))
+		}
+	}
+}
+
+func TestReferenceDecrypterInPlace(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	msg := SSH1encrypted[195 : 195+64]
+	want := make([]byte, len(msg))
+	cbc3.NewDecrypter(b1, b2, b3, benchkey[:24]).CryptBlocks(want, msg)
+	got := dup(msg)
+	cbc3.NewReferenceDecrypter(b1, b2, b3, benchkey[:24]).CryptBlocks(got, got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("in place reference decryption differs")
+	}
+}
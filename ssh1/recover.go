@@ -0,0 +1,284 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Passphrase recovery for key files whose owner has lost the passphrase.  Each
+// candidate costs an MD5, two DES key schedules and one CBC3 block, since the
+// check bytes sit in the first block of the private part.  The check bytes
+// match by chance once in 65536 wrong candidates, so a hit is confirmed by
+// decrypting and validating the whole key.
+//
+// The first block needs no KeySet or CBC3 mode: with K3 = K1 and zero IVs it
+// decrypts to D1(E2(D1(c))), so checkBlock builds just the two DES ciphers.
+
+package ssh1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/des"
+	"crypto/md5"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// ErrNotFound is returned by Recover when no candidate opens the key.
+	ErrNotFound = errors.New("ssh1: passphrase not found")
+
+	// ErrNotEncrypted is returned by Recover for a key without a
+	// passphrase.
+	ErrNotEncrypted = errors.New("ssh1: key file is not encrypted")
+)
+
+// A Rule mutates a word into candidate passphrases, calling emit for each.
+// The candidate may be reused by the rule after emit returns.
+type Rule func(word []byte, emit func(candidate []byte))
+
+// Rules are the built-in mutation rules by name.
+var Rules = map[string]Rule{
+	"lower":      mapRule(bytes.ToLower),
+	"upper":      mapRule(bytes.ToUpper),
+	"capitalize": mapRule(capitalize),
+	"reverse":    mapRule(reverse),
+	"leet":       mapRule(leet),
+	"digits":     appendDigits,
+	"years":      appendYears,
+}
+
+func mapRule(f func([]byte) []byte) Rule {
+	return func(word []byte, emit func([]byte)) { emit(f(word)) }
+}
+
+func capitalize(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	r, n := utf8.DecodeRune(b)
+	return append([]byte(string(unicode.ToUpper(r))), bytes.ToLower(b[n:])...)
+}
+
+func reverse(b []byte) []byte {
+	rs := []rune(string(b))
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return []byte(string(rs))
+}
+
+var leetMap = map[byte]byte{'a': '4', 'e': '3', 'i': '1', 'o': '0', 's': '5', 't': '7'}
+
+func leet(b []byte) []byte {
+	out := dup(b)
+	for i, c := range out {
+		if r, ok := leetMap[c]; ok {
+			out[i] = r
+		}
+	}
+	return out
+}
+
+// appendDigits appends 0 to 9 and 00 to 99.
+func appendDigits(word []byte, emit func([]byte)) {
+	c := append(dup(word), 0, 0)
+	n := len(word)
+	for i := 0; i < 10; i++ {
+		c[n] = byte('0' + i)
+		emit(c[:n+1])
+	}
+	for i := 0; i < 100; i++ {
+		c[n], c[n+1] = byte('0'+i/10), byte('0'+i%10)
+		emit(c)
+	}
+}
+
+// appendYears appends the years 1970 to 2029.
+func appendYears(word []byte, emit func([]byte)) {
+	c := append(dup(word), "0000"...)
+	n := len(word)
+	for y := 1970; y < 2030; y++ {
+		c[n], c[n+1], c[n+2], c[n+3] = byte('0'+y/1000), byte('0'+y/100%10), byte('0'+y/10%10), byte('0'+y%10)
+		emit(c)
+	}
+}
+
+func dup(b []byte) []byte { return append([]byte(nil), b...) }
+
+// RecoverOptions configures Recover.
+type RecoverOptions struct {
+	// Rules are applied to every word after it is tried as is, each on its
+	// own rather than chained.
+	Rules []Rule
+
+	// Workers is the number of goroutines testing candidates.  Zero means
+	// runtime.NumCPU().
+	Workers int
+
+	// Progress, if set, is called with the number of candidates tried
+	// every ProgressInterval, one second when zero, and once at the end.
+	Progress         func(tried uint64)
+	ProgressInterval time.Duration
+}
+
+// Recover tries every line of words, and its mutations under the rules, as
+// the passphrase of f until one opens the key, words run out or ctx is done.
+// It returns the passphrase, ErrNotEncrypted if f has none, ErrNotFound or the
+// context error.
+func Recover(ctx context.Context, f *KeyFile, words io.Reader, opts *RecoverOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &RecoverOptions{}
+	}
+	if !f.Encrypted() {
+		return nil, ErrNotEncrypted
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var tried uint64
+	var found []byte
+	var once sync.Once
+
+	// Words are handed out in batches to keep channel traffic low next to
+	// the cost of the rules.
+	const batchSize = 64
+	batches := make(chan [][]byte, workers)
+	var readErr error
+	go func() {
+		defer close(batches)
+		s := bufio.NewScanner(words)
+		batch := make([][]byte, 0, batchSize)
+		for s.Scan() {
+			batch = append(batch, dup(bytes.TrimRight(s.Bytes(), "\r")))
+			if len(batch) < batchSize {
+				continue
+			}
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			batch = make([][]byte, 0, batchSize)
+		}
+		readErr = s.Err()
+		if len(batch) > 0 {
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	// The workers drain batches until the reader closes it, so once they
+	// are done the reader is too and readErr is settled.
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n uint64
+			try := func(c []byte) {
+				if ctx.Err() != nil {
+					return
+				}
+				n++
+				if checkBlock(f.private[:8], c) {
+					if _, err := f.Decrypt(c); err == nil {
+						once.Do(func() {
+							found = dup(c)
+							cancel()
+						})
+					}
+				}
+			}
+			for batch := range batches {
+				for _, w := range batch {
+					if ctx.Err() != nil {
+						break
+					}
+					try(w)
+					for _, r := range opts.Rules {
+						if ctx.Err() != nil {
+							break
+						}
+						r(w, try)
+					}
+				}
+				atomic.AddUint64(&tried, n)
+				n = 0
+			}
+		}()
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	if opts.Progress == nil {
+		close(stopped)
+	} else {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		go func() {
+			defer close(stopped)
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					opts.Progress(atomic.LoadUint64(&tried))
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-stopped
+	if opts.Progress != nil {
+		opts.Progress(atomic.LoadUint64(&tried))
+	}
+
+	switch {
+	case found != nil:
+		return found, nil
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case readErr != nil:
+		return nil, readErr
+	}
+	return nil, ErrNotFound
+}
+
+// checkBlock reports whether passphrase gives the check bytes when decrypting
+// the first block c of the private part, as CheckPassphrase does.
+func checkBlock(c, passphrase []byte) bool {
+	h := md5.Sum(passphrase)
+	b1, _ := des.NewCipher(h[:8])
+	b2, _ := des.NewCipher(h[8:])
+	var p [8]byte
+	b1.Decrypt(p[:], c)
+	b2.Encrypt(p[:], p[:])
+	b1.Decrypt(p[:], p[:])
+	return p[0] == p[2] && p[1] == p[3]
+}
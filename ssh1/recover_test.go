@@ -0,0 +1,121 @@
+package ssh1_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pschou/go-cbc3/ssh1"
+)
+
+func wordlist(n int, extra ...string) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "word%d\n", i)
+	}
+	for _, w := range extra {
+		b.WriteString(w + "\r\n")
+	}
+	return b.String()
+}
+
+func TestRecover(t *testing.T) {
+	f := readKeyFile(t, "testdata/identity")
+	for _, tc := range []struct {
+		word  string
+		rules []string
+	}{
+		{"testit", nil},
+		{"TESTIT", []string{"lower"}},
+		{"titset", []string{"upper", "reverse"}},
+	} {
+		var last uint64
+		opts := &ssh1.RecoverOptions{
+			Workers:  4,
+			Progress: func(n uint64) { atomic.StoreUint64(&last, n) },
+		}
+		for _, r := range tc.rules {
+			opts.Rules = append(opts.Rules, ssh1.Rules[r])
+		}
+		pass, err := ssh1.Recover(context.Background(), f, strings.NewReader(wordlist(1000, tc.word)), opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.word, err)
+		}
+		if string(pass) != "testit" {
+			t.Errorf("%s: recovered %q", tc.word, pass)
+		}
+		if atomic.LoadUint64(&last) == 0 {
+			t.Errorf("%s: no progress reported", tc.word)
+		}
+	}
+
+	_, err := ssh1.Recover(context.Background(), f, strings.NewReader(wordlist(100)), nil)
+	if err != ssh1.ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	plain := readKeyFile(t, "testdata/identity.plain")
+	_, err = ssh1.Recover(context.Background(), plain, strings.NewReader(wordlist(1)), nil)
+	if err != ssh1.ErrNotEncrypted {
+		t.Errorf("got %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestRecoverCancel(t *testing.T) {
+	f := readKeyFile(t, "testdata/identity")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ssh1.Recover(ctx, f, strings.NewReader(wordlist(100000, "testit")), nil)
+	if err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestRecoverCancelInRules(t *testing.T) {
+	// Candidates from the rules stop as soon as the context is done, not at
+	// the next word.
+	f := readKeyFile(t, "testdata/identity")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last uint64
+	opts := &ssh1.RecoverOptions{
+		Workers:  1,
+		Progress: func(n uint64) { atomic.StoreUint64(&last, n) },
+		Rules: []ssh1.Rule{
+			func(w []byte, emit func([]byte)) {
+				cancel()
+				for i := 0; i < 1000; i++ {
+					emit(w)
+				}
+			},
+			func(w []byte, emit func([]byte)) { t.Errorf("rule run after cancel") },
+		},
+	}
+	_, err := ssh1.Recover(ctx, f, strings.NewReader("word\n"), opts)
+	if err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if n := atomic.LoadUint64(&last); n != 1 {
+		t.Errorf("tried %d candidates, want 1", n)
+	}
+}
+
+func TestRules(t *testing.T) {
+	for name, want := range map[string][]string{
+		"capitalize": {"Secret"},
+		"reverse":    {"terceS"},
+		"leet":       {"S3cr37"},
+		"years":      {"Secret1970", "Secret2029"},
+		"digits":     {"Secret0", "Secret9", "Secret00", "Secret99"},
+	} {
+		seen := map[string]bool{}
+		ssh1.Rules[name]([]byte("Secret"), func(c []byte) { seen[string(c)] = true })
+		for _, w := range want {
+			if !seen[w] {
+				t.Errorf("%s: %q not produced", name, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ssh1 reads and writes SSH-1 RSA private key files, the format of
+// ~/.ssh/identity.  The file is laid out as:
+//
+//	"SSH PRIVATE KEY FILE FORMAT 1.1\n\0"
+//	cipher type  uint8, 0 for none or 3 for 3DES
+//	reserved     uint32
+//	bits         uint32
+//	n, e         mpint, the public key
+//	comment      uint32 length, then the comment
+//	private part c1 c2 c1 c2 check bytes, mpints d, u, q, p and zero padding
+//	             to a multiple of 8 bytes
+//
+// An mpint is a 16 bit bit count followed by the big-endian value.  With
+// 3DES the private part is encrypted in CBC3 under cbc3.SSH1KeySet of the
+// passphrase, and the repeated check bytes show whether the passphrase was
+// right.  u is q^-1 mod p.
+package ssh1 // import "github.com/pschou/go-cbc3/ssh1"
+
+import (
+	"bytes"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+// Cipher types of the SSH-1 key file.
+const (
+	CipherNone = 0
+	Cipher3DES = 3
+)
+
+const magic = "SSH PRIVATE KEY FILE FORMAT 1.1\n\x00"
+
+var (
+	// ErrPassphrase is returned when the check bytes do not match after
+	// decryption.
+	ErrPassphrase = errors.New("ssh1: incorrect passphrase")
+
+	errFormat = errors.New("ssh1: not an SSH-1 private key file")
+	errShort  = errors.New("ssh1: key file truncated")
+)
+
+// KeyFile is a parsed SSH-1 key file.  The public part is available right
+// away; the private part stays as stored until Decrypt.
+type KeyFile struct {
+	CipherType byte
+	Bits       uint32
+	N, E       *big.Int
+	Comment    string
+
+	private []byte
+}
+
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 {
+		r.err = errFormat
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = errShort
+		return nil
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p
+}
+
+func (r *reader) uint32() uint32 {
+	if p := r.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+// string reads a 32 bit length and that many bytes.  The length is checked
+// before it is converted to an int, which may be only 32 bits wide.
+func (r *reader) string() []byte {
+	n := r.uint32()
+	if r.err == nil && uint64(n) > uint64(len(r.b)) {
+		r.err = errFormat
+		return nil
+	}
+	return r.next(int(n))
+}
+
+func (r *reader) mpint() *big.Int {
+	p := r.next(2)
+	if p == nil {
+		return nil
+	}
+	bits := int(binary.BigEndian.Uint16(p))
+	if p = r.next((bits + 7) / 8); p == nil {
+		return nil
+	}
+	return new(big.Int).SetBytes(p)
+}
+
+// ParseKeyFile parses the contents of an SSH-1 private key file.
+func ParseKeyFile(data []byte) (*KeyFile, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, errFormat
+	}
+	r := &reader{b: data[len(magic):]}
+	f := &KeyFile{}
+	if p := r.next(1); p != nil {
+		f.CipherType = p[0]
+	}
+	r.uint32() // reserved
+	f.Bits = r.uint32()
+	f.N = r.mpint()
+	f.E = r.mpint()
+	f.Comment = string(r.string())
+	if r.err != nil {
+		return nil, r.err
+	}
+	if f.CipherType != CipherNone && f.CipherType != Cipher3DES {
+		return nil, fmt.Errorf("ssh1: unsupported cipher type %d", f.CipherType)
+	}
+	if len(r.b) < 8 || len(r.b)%8 != 0 {
+		return nil, errors.New("ssh1: private part is not a whole number of blocks")
+	}
+	f.private = r.b
+	return f, nil
+}
+
+// PublicKey returns the RSA public key.
+func (f *KeyFile) PublicKey() *rsa.PublicKey {
+	return &rsa.PublicKey{N: f.N, E: int(f.E.Int64())}
+}
+
+// Fingerprint returns the SSH-1 fingerprint, the MD5 hash of the modulus and
+// public exponent, in colon separated hex.
+func (f *KeyFile) Fingerprint() string {
+	h := md5.New()
+	h.Write(f.N.Bytes())
+	h.Write(f.E.Bytes())
+	var parts []string
+	for _, b := range h.Sum(nil) {
+		parts = append(parts, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+// Encrypted reports whether the private part is protected by a passphrase.
+func (f *KeyFile) Encrypted() bool { return f.CipherType != CipherNone }
+
+// CheckBlock returns the first 8 bytes of the private part as stored, which
+// hold the check bytes, for quick passphrase testing with CheckPassphrase.
+func (f *KeyFile) CheckBlock() []byte { return f.private[:8] }
+
+// CheckPassphrase reports whether passphrase opens the key, decrypting only
+// the first block of the private part.
+func (f *KeyFile) CheckPassphrase(passphrase []byte) bool {
+	if !f.Encrypted() {
+		return true
+	}
+	var block [8]byte
+	decrypt(passphrase, block[:], f.private[:8])
+	return block[0] == block[2] && block[1] == block[3]
+}
+
+func decrypt(passphrase, dst, src []byte) {
+	mode, _ := cbc3.SSH1KeySet(passphrase).NewDecrypter(des.NewCipher, nil)
+	mode.CryptBlocks(dst, src)
+}
+
+// Decrypt decrypts the private part with passphrase, which is ignored for an
+// unencrypted file, and returns the private key.
+func (f *KeyFile) Decrypt(passphrase []byte) (*rsa.PrivateKey, error) {
+	p := f.private
+	if f.Encrypted() {
+		p = make([]byte, len(f.private))
+		decrypt(passphrase, p, f.private)
+	}
+	if p[0] != p[2] || p[1] != p[3] {
+		return nil, ErrPassphrase
+	}
+	r := &reader{b: p[4:]}
+	d := r.mpint()
+	r.mpint() // u, recomputed by Precompute
+	q := r.mpint()
+	pr := r.mpint()
+	if r.err != nil {
+		return nil, r.err
+	}
+	key := &rsa.PrivateKey{
+		PublicKey: *f.PublicKey(),
+		D:         d,
+		Primes:    []*big.Int{pr, q},
+	}
+	if err := key.Validate(); err != nil {
+		return nil, fmt.Errorf("ssh1: invalid private key: %v", err)
+	}
+	key.Precompute()
+	return key, nil
+}
+
+type writer struct{ bytes.Buffer }
+
+func (w *writer) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *writer) mpint(v *big.Int) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v.BitLen()))
+	w.Write(b[:])
+	w.Write(v.Bytes())
+}
+
+// Marshal returns key as an SSH-1 private key file.  The private part is
+// encrypted with 3DES under passphrase, or left in the clear if passphrase is
+// empty.  The key must have exactly two primes.
+func Marshal(key *rsa.PrivateKey, comment string, passphrase []byte) ([]byte, error) {
+	if len(key.Primes) != 2 {
+		return nil, errors.New("ssh1: key must have two primes")
+	}
+	p, q := key.Primes[0], key.Primes[1]
+	u := new(big.Int).ModInverse(q, p)
+	if u == nil {
+		return nil, errors.New("ssh1: invalid key primes")
+	}
+
+	var priv writer
+	check := make([]byte, 2)
+	if _, err := io.ReadFull(rand.Reader, check); err != nil {
+		return nil, err
+	}
+	priv.Write(check)
+	priv.Write(check)
+	priv.mpint(key.D)
+	priv.mpint(u)
+	priv.mpint(q)
+	priv.mpint(p)
+	for priv.Len()%8 != 0 {
+		priv.WriteByte(0)
+	}
+
+	var w writer
+	w.WriteString(magic)
+	cipherType := byte(CipherNone)
+	if len(passphrase) > 0 {
+		cipherType = Cipher3DES
+	}
+	w.WriteByte(cipherType)
+	w.uint32(0)
+	w.uint32(uint32(key.N.BitLen()))
+	w.mpint(key.N)
+	w.mpint(big.NewInt(int64(key.E)))
+	w.uint32(uint32(len(comment)))
+	w.WriteString(comment)
+
+	body := priv.Bytes()
+	if cipherType == Cipher3DES {
+		mode, _ := cbc3.SSH1KeySet(passphrase).NewEncrypter(des.NewCipher, nil)
+		mode.CryptBlocks(body, body)
+	}
+	w.Write(body)
+	return w.Bytes(), nil
+}
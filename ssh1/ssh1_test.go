@@ -0,0 +1,130 @@
+package ssh1_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pschou/go-cbc3/ssh1"
+)
+
+func readKeyFile(t *testing.T, name string) *ssh1.KeyFile {
+	t.Helper()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ssh1.ParseKeyFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestParseKeyFile(t *testing.T) {
+	enc := readKeyFile(t, "testdata/identity")
+	plain := readKeyFile(t, "testdata/identity.plain")
+	if enc.CipherType != ssh1.Cipher3DES || plain.CipherType != ssh1.CipherNone {
+		t.Errorf("cipher types %d and %d", enc.CipherType, plain.CipherType)
+	}
+	if enc.Bits != uint32(enc.N.BitLen()) {
+		t.Errorf("bits %d, modulus has %d", enc.Bits, enc.N.BitLen())
+	}
+	if enc.N.Cmp(plain.N) != 0 || enc.Comment != plain.Comment {
+		t.Errorf("public parts differ")
+	}
+	if fp := enc.Fingerprint(); len(fp) != 47 || fp != plain.Fingerprint() {
+		t.Errorf("fingerprint %q", fp)
+	}
+
+	if _, err := ssh1.ParseKeyFile([]byte("SSH PRIVATE KEY FILE FORMAT 1.1\n")); err == nil {
+		t.Errorf("parsed a truncated file")
+	}
+
+	// A comment length past the end of the file, negative as an int32.
+	data, _ := ioutil.ReadFile("testdata/identity")
+	i := bytes.Index(data, []byte(enc.Comment)) - 4
+	for _, n := range [][]byte{{0xff, 0xff, 0xff, 0xff}, {0x80, 0, 0, 0}, {0, 0, 0x10, 0}} {
+		bad := append([]byte{}, data...)
+		copy(bad[i:], n)
+		if _, err := ssh1.ParseKeyFile(bad); err == nil {
+			t.Errorf("comment length %x accepted", n)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	enc := readKeyFile(t, "testdata/identity")
+	if enc.CheckPassphrase([]byte("wrong")) {
+		t.Errorf("wrong passphrase accepted")
+	}
+	if !enc.CheckPassphrase([]byte("testit")) {
+		t.Fatalf("passphrase rejected")
+	}
+	if _, err := enc.Decrypt([]byte("wrong")); err != ssh1.ErrPassphrase {
+		t.Errorf("got %v, want ErrPassphrase", err)
+	}
+	key, err := enc.Decrypt([]byte("testit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainKey, err := readKeyFile(t, "testdata/identity.plain").Decrypt(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.D.Cmp(plainKey.D) != 0 {
+		t.Errorf("private exponents differ")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	key, err := readKeyFile(t, "testdata/identity").Decrypt([]byte("testit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pass := range []string{"", "new passphrase"} {
+		data, err := ssh1.Marshal(key, "round trip", []byte(pass))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ssh1.ParseKeyFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Encrypted() != (pass != "") || f.Comment != "round trip" {
+			t.Errorf("%q: cipher type %d, comment %q", pass, f.CipherType, f.Comment)
+		}
+		got, err := f.Decrypt([]byte(pass))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(key) {
+			t.Errorf("%q: key changed in round trip", pass)
+		}
+	}
+
+	// The layout matches the fixture apart from the random check bytes.
+	plain, err := ioutil.ReadFile("testdata/identity.plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := readKeyFile(t, "testdata/identity.plain")
+	data, err := ssh1.Marshal(key, f.Comment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const check = 195
+	if !bytes.Equal(data[:check], plain[:check]) || !bytes.Equal(data[check+4:], plain[check+4:]) {
+		t.Errorf("marshalled file differs from the fixture")
+	}
+
+	multi, err := rsa.GenerateMultiPrimeKey(rand.Reader, 3, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssh1.Marshal(multi, "", nil); err == nil {
+		t.Errorf("marshalled a three prime key")
+	}
+}
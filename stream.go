@@ -0,0 +1,273 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The segmented stream format cuts the plaintext into fixed size segments so
+// that arbitrarily large inputs can be encrypted and authenticated with a
+// bounded amount of memory.  A stream is laid out as:
+//
+//   triple IV || segment 0 || segment 1 || ... || final segment
+//
+// Each segment is the CBC3 ciphertext of segmentSize plaintext bytes followed
+// by an HMAC-SHA-256 tag over the triple IV, the 64 bit big-endian segment
+// index, a final-segment flag byte and the segment ciphertext.  The chaining
+// state carries across segments.  The final segment holds the remaining
+// plaintext with padding (PKCS#7 unless the container header names another
+// scheme), so it is always present, and is the only segment whose tag is
+// computed with the final flag set.  Dropping, reordering or truncating
+// segments therefore breaks authentication.
+
+package cbc3
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+var (
+	errSegmentAuth = errors.New("cbc3: segment authentication failed")
+	errClosed      = errors.New("cbc3: write to closed writer")
+)
+
+type segmentWriter struct {
+	w        io.Writer
+	mode     cipher.BlockMode
+	mac      hash.Hash
+	ad       []byte
+	padding  Padding
+	buf      []byte
+	segSize  int
+	index    uint64
+	err      error
+	finished bool
+}
+
+// NewSegmentWriter returns a writer which encrypts everything written to it
+// into the segmented stream format using the given three Blocks and MAC key.
+// A random triple IV is drawn and written to w first.  segmentSize is the
+// number of plaintext bytes in each segment and must be a positive multiple
+// of the block size.  Close must be called to write the final segment; it does
+// not close w.
+func NewSegmentWriter(w io.Writer, b1, b2, b3 cipher.Block, macKey []byte, segmentSize int) (io.WriteCloser, error) {
+	if err := checkSegmentSize(b1, b2, b3, segmentSize); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, 3*b1.BlockSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(iv); err != nil {
+		return nil, err
+	}
+	return newSegmentWriter(w, NewEncrypter(b1, b2, b3, iv), PKCS7Padding, macKey, iv, segmentSize), nil
+}
+
+// newSegmentWriter writes segments encrypted with mode, binding each tag to
+// ad.  The padding must always add at least one byte.
+func newSegmentWriter(w io.Writer, mode cipher.BlockMode, padding Padding, macKey, ad []byte, segmentSize int) *segmentWriter {
+	return &segmentWriter{
+		w:       w,
+		mode:    mode,
+		mac:     hmac.New(sha256.New, macKey),
+		ad:      dup(ad),
+		padding: padding,
+		buf:     make([]byte, 0, segmentSize+mode.BlockSize()),
+		segSize: segmentSize,
+	}
+}
+
+func (s *segmentWriter) Write(p []byte) (n int, err error) {
+	if s.finished {
+		return 0, errClosed
+	}
+	for len(p) > 0 && s.err == nil {
+		// A full segment is only flushed once more data shows up, as the
+		// last one written has to carry the final flag.
+		if len(s.buf) == s.segSize {
+			s.flush(false)
+			continue
+		}
+		m := s.segSize - len(s.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		s.buf = append(s.buf, p[:m]...)
+		n += m
+		p = p[m:]
+	}
+	return n, s.err
+}
+
+// Close pads and writes the final segment.
+func (s *segmentWriter) Close() error {
+	if s.finished {
+		return s.err
+	}
+	s.finished = true
+	if s.err == nil {
+		s.flush(true)
+	}
+	return s.err
+}
+
+func (s *segmentWriter) flush(final bool) {
+	if final {
+		s.buf = s.padding.Pad(s.buf, s.mode.BlockSize())
+	}
+	s.mode.CryptBlocks(s.buf, s.buf)
+	tag := segmentTag(s.mac, s.ad, s.index, final, s.buf)
+	if _, err := s.w.Write(s.buf); err != nil {
+		s.err = err
+		return
+	}
+	if _, err := s.w.Write(tag); err != nil {
+		s.err = err
+		return
+	}
+	s.index++
+	s.buf = s.buf[:0]
+}
+
+type segmentReader struct {
+	r         *bufio.Reader
+	mode      cipher.BlockMode
+	mac       hash.Hash
+	ad        []byte
+	padding   Padding
+	segSize   int
+	maxRecord int
+	buf       []byte
+	out       []byte
+	index     uint64
+	done      bool
+	err       error
+}
+
+// NewSegmentReader returns a reader which decrypts a stream written by
+// NewSegmentWriter with the same Blocks, MAC key and segment size.  Each
+// segment is authenticated before any of its plaintext is returned; a
+// modified, reordered or truncated stream results in an error.
+func NewSegmentReader(r io.Reader, b1, b2, b3 cipher.Block, macKey []byte, segmentSize int) (io.Reader, error) {
+	if err := checkSegmentSize(b1, b2, b3, segmentSize); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, 3*b1.BlockSize())
+	if _, err := io.ReadFull(r, iv); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return newSegmentReader(r, NewDecrypter(b1, b2, b3, iv), PKCS7Padding, macKey, iv, segmentSize), nil
+}
+
+func newSegmentReader(r io.Reader, mode cipher.BlockMode, padding Padding, macKey, ad []byte, segmentSize int) *segmentReader {
+	mac := hmac.New(sha256.New, macKey)
+	// The longest record is a final segment holding a full segment of
+	// plaintext and a whole block of padding.
+	maxRecord := segmentSize + mode.BlockSize() + mac.Size()
+	return &segmentReader{
+		r:         bufio.NewReaderSize(r, maxRecord+1),
+		mode:      mode,
+		mac:       mac,
+		ad:        dup(ad),
+		padding:   padding,
+		segSize:   segmentSize,
+		maxRecord: maxRecord,
+		buf:       make([]byte, segmentSize+mode.BlockSize()),
+	}
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// next reads, authenticates and decrypts one segment.  Whether the segment is
+// the final one is decided by looking ahead far enough to see if another
+// segment follows.
+func (s *segmentReader) next() error {
+	peek, err := s.r.Peek(s.maxRecord + 1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	bs, tagSize := s.mode.BlockSize(), s.mac.Size()
+	final := len(peek) <= s.maxRecord
+	record := len(peek)
+	if !final {
+		record = s.segSize + tagSize
+	}
+	n := record - tagSize
+	if n < bs || n%bs != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	ciphertext, tag := peek[:n], peek[n:record]
+	if !hmac.Equal(tag, segmentTag(s.mac, s.ad, s.index, final, ciphertext)) {
+		return errSegmentAuth
+	}
+	s.mode.CryptBlocks(s.buf[:n], ciphertext)
+	if _, err := s.r.Discard(record); err != nil {
+		return err
+	}
+	s.index++
+	s.out = s.buf[:n]
+	if final {
+		s.done = true
+		if s.out, err = s.padding.Unpad(s.out, bs); err != nil {
+			return errSegmentAuth
+		}
+	}
+	return nil
+}
+
+// segmentTag computes HMAC(ad || index || final || ciphertext).
+func segmentTag(mac hash.Hash, ad []byte, index uint64, final bool, ciphertext []byte) []byte {
+	var hdr [9]byte
+	binary.BigEndian.PutUint64(hdr[:8], index)
+	if final {
+		hdr[8] = 1
+	}
+	mac.Reset()
+	mac.Write(ad)
+	mac.Write(hdr[:])
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+func checkSegmentSize(b1, b2, b3 cipher.Block, segmentSize int) error {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		return errBlockSizes
+	}
+	if segmentSize <= 0 || segmentSize%bs != 0 {
+		return errors.New("cbc3: segment size must be a positive multiple of the block size")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func segmentBlocks(t *testing.T) (b1, b2, b3 cipher.Block) {
+	b1, err := aes.NewCipher(benchkey[:16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, _ = aes.NewCipher(benchkey[8:24])
+	b3, _ = aes.NewCipher(benchkey[16:32])
+	return
+}
+
+func sealSegments(t *testing.T, plaintext []byte, segmentSize int) []byte {
+	b1, b2, b3 := segmentBlocks(t)
+	var buf bytes.Buffer
+	w, err := cbc3.NewSegmentWriter(&buf, b1, b2, b3, []byte("mac key"), segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in odd sized pieces to exercise the internal buffering.
+	for p := plaintext; len(p) > 0; {
+		n := 7
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openSegments(t *testing.T, stream []byte, segmentSize int) ([]byte, error) {
+	b1, b2, b3 := segmentBlocks(t)
+	r, err := cbc3.NewSegmentReader(bytes.NewReader(stream), b1, b2, b3, []byte("mac key"), segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	const segmentSize = 64
+	for _, n := range []int{0, 1, 15, 16, 63, 64, 65, 128, 129, 1000} {
+		plaintext := make([]byte, n)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+		stream := sealSegments(t, plaintext, segmentSize)
+		got, err := openSegments(t, stream, segmentSize)
+		if err != nil {
+			t.Fatalf("%d bytes: %s", n, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: round trip mismatch", n)
+		}
+	}
+}
+
+func TestSegmentCopy(t *testing.T) {
+	b1, b2, b3 := segmentBlocks(t)
+	plaintext := bytes.Repeat([]byte("0123456789"), 1000)
+	var buf bytes.Buffer
+	w, _ := cbc3.NewSegmentWriter(&buf, b1, b2, b3, []byte("mac key"), 256)
+	if _, err := io.Copy(w, bytes.NewReader(plaintext)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	r, _ := cbc3.NewSegmentReader(&buf, b1, b2, b3, []byte("mac key"), 256)
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("io.Copy round trip mismatch")
+	}
+}
+
+func TestSegmentTamper(t *testing.T) {
+	const segmentSize = 32
+	const record = segmentSize + 32
+	plaintext := bytes.Repeat([]byte{'a'}, 3*segmentSize+5)
+	stream := sealSegments(t, plaintext, segmentSize)
+	body := stream[48:]
+
+	// Truncation at a segment boundary.
+	if _, err := openSegments(t, stream[:48+2*record], segmentSize); err == nil {
+		t.Errorf("truncation at a segment boundary was not detected")
+	}
+	// Truncation inside a segment.
+	if _, err := openSegments(t, stream[:len(stream)-1], segmentSize); err == nil {
+		t.Errorf("truncation inside a segment was not detected")
+	}
+	// Swapping the first two segments.
+	swapped := append([]byte{}, stream[:48]...)
+	swapped = append(swapped, body[record:2*record]...)
+	swapped = append(swapped, body[:record]...)
+	swapped = append(swapped, body[2*record:]...)
+	if _, err := openSegments(t, swapped, segmentSize); err == nil {
+		t.Errorf("reordered segments were not detected")
+	}
+	// Flipping a bit anywhere.
+	for i := 0; i < len(stream); i += 11 {
+		bad := append([]byte{}, stream...)
+		bad[i] ^= 0x80
+		if _, err := openSegments(t, bad, segmentSize); err == nil {
+			t.Errorf("flipped byte %d was not detected", i)
+		}
+	}
+}
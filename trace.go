@@ -0,0 +1,171 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Tracing shows every block passing through the three layers, for finding
+// which layer disagrees with another implementation and for producing test
+// vectors.  Without a tracer CryptBlocks runs the untouched loop and pays one
+// nil check per call; with one it takes a separate, slower path.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LayerTrace holds the values of one block in one layer.  Mid is the value
+// between the IV XOR and the block function: for encryption layers 1 and 3 it
+// is In XOR the register, for the middle layer the block function output, and
+// the reverse when decrypting.
+type LayerTrace struct {
+	In, Mid, Out []byte
+}
+
+// BlockTrace records one block of CryptBlocks.  Layers are indexed by stage,
+// Layers[0] being the layer around b1, whichever the direction.
+type BlockTrace struct {
+	Index    uint64 // block number since the tracer was set
+	Decrypt  bool
+	IVBefore []byte // the triple IV registers before the block
+	IVAfter  []byte // and after
+	Layers   [3]LayerTrace
+}
+
+// Tracer receives a BlockTrace for every block processed by a CBC3 encrypter
+// or decrypter it is set on.  The trace and its slices are not reused, so
+// they may be kept.
+type Tracer interface {
+	TraceBlock(t *BlockTrace)
+}
+
+// SetTracer installs t on a mode from NewEncrypter or NewDecrypter; a nil t
+// turns tracing off.
+func SetTracer(mode cipher.BlockMode, t Tracer) error {
+	m, ok := mode.(interface{ SetTracer(Tracer) })
+	if !ok {
+		return errors.New("cbc3: mode does not support tracing")
+	}
+	m.SetTracer(t)
+	return nil
+}
+
+// SetTracer installs t, or turns tracing off if t is nil.
+func (x *Encrypter) SetTracer(t Tracer) { x.tracer, x.traced = t, 0 }
+
+// SetTracer installs t, or turns tracing off if t is nil.
+func (x *Decrypter) SetTracer(t Tracer) { x.tracer, x.traced = t, 0 }
+
+// cryptBlocksTraced is the CryptBlocks loop for both directions with every
+// intermediate value copied out.  The arguments have already been checked.
+func (x *cbc) cryptBlocksTraced(dst, src []byte, decrypt bool) {
+	bs := x.blockSize
+	iv := [3][]byte{x.iv[:bs], x.iv[bs : 2*bs], x.iv[2*bs:]}
+	buf := make([]byte, bs)
+	for len(src) > 0 {
+		t := &BlockTrace{Index: x.traced, Decrypt: decrypt, IVBefore: dup(x.iv)}
+		step := func(layer int, in []byte, f func()) {
+			t.Layers[layer].In = dup(in)
+			f()
+			t.Layers[layer].Mid = dup(buf)
+		}
+		if !decrypt {
+			step(0, src[:bs], func() { xorBytes(buf, src[:bs], iv[0]) })
+			x.b1.Encrypt(buf, buf)
+			t.Layers[0].Out = dup(buf)
+			copy(iv[0], buf)
+
+			step(1, buf, func() { x.b2.Decrypt(buf, buf) })
+			xorBytes(buf, buf, iv[1])
+			t.Layers[1].Out = dup(buf)
+			copy(iv[1], iv[0])
+
+			step(2, buf, func() { xorBytes(buf, buf, iv[2]) })
+			x.b3.Encrypt(buf, buf)
+			t.Layers[2].Out = dup(buf)
+			copy(iv[2], buf)
+		} else {
+			c := dup(src[:bs])
+			step(2, c, func() { x.b3.Decrypt(buf, c) })
+			xorBytes(buf, buf, iv[2])
+			t.Layers[2].Out = dup(buf)
+			copy(iv[2], c)
+
+			step(1, buf, func() { xorBytes(buf, buf, iv[1]) })
+			x.b2.Encrypt(buf, buf)
+			t.Layers[1].Out = dup(buf)
+			copy(iv[1], buf)
+
+			step(0, buf, func() { x.b1.Decrypt(buf, buf) })
+			xorBytes(buf, buf, iv[0])
+			t.Layers[0].Out = dup(buf)
+			copy(iv[0], iv[1])
+		}
+		copy(dst, buf)
+		t.IVAfter = dup(x.iv)
+		x.traced++
+		x.tracer.TraceBlock(t)
+
+		src = src[bs:]
+		dst = dst[bs:]
+	}
+}
+
+// TextTracer writes each BlockTrace as lines of hex, every line starting
+// with the block number and direction so two dumps can be compared with diff:
+//
+//	0 enc iv  <reg1> <reg2> <reg3>
+//	0 enc L1  in <x> mid <x> out <x>
+//	0 enc L2  in <x> mid <x> out <x>
+//	0 enc L3  in <x> mid <x> out <x>
+//	0 enc iv' <reg1> <reg2> <reg3>
+//
+// The layer lines are always in stage order, also when decrypting.
+type TextTracer struct {
+	w   io.Writer
+	err error
+}
+
+// NewTextTracer returns a TextTracer writing to w.
+func NewTextTracer(w io.Writer) *TextTracer {
+	return &TextTracer{w: w}
+}
+
+func (tt *TextTracer) TraceBlock(t *BlockTrace) {
+	if tt.err != nil {
+		return
+	}
+	dir := "enc"
+	if t.Decrypt {
+		dir = "dec"
+	}
+	regs := func(iv []byte) string {
+		bs := len(iv) / 3
+		return fmt.Sprintf("%x %x %x", iv[:bs], iv[bs:2*bs], iv[2*bs:])
+	}
+	_, tt.err = fmt.Fprintf(tt.w, "%d %s iv  %s\n", t.Index, dir, regs(t.IVBefore))
+	for i, l := range t.Layers {
+		if tt.err == nil {
+			_, tt.err = fmt.Fprintf(tt.w, "%d %s L%d  in %x mid %x out %x\n", t.Index, dir, i+1, l.In, l.Mid, l.Out)
+		}
+	}
+	if tt.err == nil {
+		_, tt.err = fmt.Fprintf(tt.w, "%d %s iv' %s\n", t.Index, dir, regs(t.IVAfter))
+	}
+}
+
+// Err returns the first error from the underlying writer.  Once a write has
+// failed nothing more is written.
+func (tt *TextTracer) Err() error { return tt.err }
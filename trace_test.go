@@ -0,0 +1,107 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"fmt"
+	"strings"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+type traceList []*cbc3.BlockTrace
+
+func (l *traceList) TraceBlock(t *cbc3.BlockTrace) { *l = append(*l, t) }
+
+func TestTracer(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	iv := benchkey[:24]
+	msg := []byte("traced blocks 0123456789")
+
+	want := make([]byte, len(msg))
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, msg)
+
+	var enc traceList
+	mode := cbc3.NewEncrypter(b1, b2, b3, iv)
+	if err := cbc3.SetTracer(mode, &enc); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	mode.CryptBlocks(got[:8], msg[:8])
+	mode.CryptBlocks(got[8:], msg[8:])
+	if !bytes.Equal(got, want) {
+		t.Fatalf("tracing changed the ciphertext")
+	}
+	if len(enc) != 3 {
+		t.Fatalf("got %d traces, want 3", len(enc))
+	}
+
+	var dec traceList
+	dmode := cbc3.NewDecrypter(b1, b2, b3, iv)
+	cbc3.SetTracer(dmode, &dec)
+	pt := dup(got)
+	dmode.CryptBlocks(pt, pt)
+	if !bytes.Equal(pt, msg) {
+		t.Fatalf("traced decryption failed")
+	}
+
+	for i, tr := range enc {
+		l := tr.Layers
+		if tr.Index != uint64(i) || tr.Decrypt {
+			t.Errorf("block %d: index %d decrypt %v", i, tr.Index, tr.Decrypt)
+		}
+		if !bytes.Equal(l[0].In, msg[8*i:8*i+8]) || !bytes.Equal(l[2].Out, got[8*i:8*i+8]) {
+			t.Errorf("block %d: layer ends do not match the data", i)
+		}
+		if !bytes.Equal(l[0].Out, l[1].In) || !bytes.Equal(l[1].Out, l[2].In) {
+			t.Errorf("block %d: layers do not chain", i)
+		}
+		if i > 0 && !bytes.Equal(enc[i-1].IVAfter, tr.IVBefore) {
+			t.Errorf("block %d: IV registers do not carry over", i)
+		}
+		// Decryption passes the same values between the layers.
+		d := dec[i].Layers
+		if !bytes.Equal(d[2].Out, l[2].In) || !bytes.Equal(d[1].Out, l[1].In) || !bytes.Equal(d[0].Out, l[0].In) {
+			t.Errorf("block %d: decryption trace does not mirror encryption", i)
+		}
+	}
+
+	cbc3.SetTracer(mode, nil)
+	mode.CryptBlocks(got[:8], msg[:8])
+	if len(enc) != 3 {
+		t.Errorf("tracer still called after removal")
+	}
+}
+
+func TestTextTracer(t *testing.T) {
+	b, _ := des.NewCipher(benchkey[:8])
+	var buf bytes.Buffer
+	mode := cbc3.NewEncrypter(b, b, b, make([]byte, 24))
+	cbc3.SetTracer(mode, cbc3.NewTextTracer(&buf))
+	mode.CryptBlocks(make([]byte, 16), make([]byte, 16))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("got %d lines, want 10:\n%s", len(lines), buf.String())
+	}
+	zero := strings.Repeat("0", 16)
+	if want := fmt.Sprintf("0 enc iv  %s %s %s", zero, zero, zero); lines[0] != want {
+		t.Errorf("got %q, want %q", lines[0], want)
+	}
+	for i, prefix := range []string{"0 enc L1  in ", "0 enc L2  in ", "0 enc L3  in ", "0 enc iv' ", "1 enc iv  "} {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Errorf("line %d: %q does not start with %q", i+1, lines[i+1], prefix)
+		}
+	}
+}
+
+func TestSetTracerUnsupported(t *testing.T) {
+	b, _ := des.NewCipher(benchkey[:8])
+	if cbc3.SetTracer(cipher.NewCBCEncrypter(b, make([]byte, 8)), cbc3.NewTextTracer(&bytes.Buffer{})) == nil {
+		t.Errorf("SetTracer accepted a standard library mode")
+	}
+}
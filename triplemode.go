@@ -0,0 +1,220 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The triple modes of Biham, "Cryptanalysis of triple modes of operation".  A
+// triple mode stacks three single modes, each around its own block cipher, and
+// is named by its layers from the plaintext side, such as CBC|CBC^-1|CBC.  The
+// layers are written in the encryption direction, so CBC^-1 is CBC decryption
+// run forwards, with the decryption function of its block.  CBC|CBC^-1|CBC
+// with the registers of a triple IV is exactly CBC3.
+
+package cbc3
+
+import (
+	"crypto/cipher"
+	"errors"
+	"strings"
+)
+
+// Layer is a single mode of operation used as one layer of a triple mode.
+type Layer int
+
+const (
+	// LayerECB encrypts each block on its own, y = E(x).
+	LayerECB Layer = iota + 1
+	// LayerCBC chains on the output, y = E(x ^ y').
+	LayerCBC
+	// LayerCBCInv is CBC decryption run forwards, y = D(x) ^ x'.
+	LayerCBCInv
+	// LayerCFB is full block cipher feedback, y = x ^ E(y').
+	LayerCFB
+	// LayerOFB is output feedback, y = x ^ s with s = E(s').
+	LayerOFB
+)
+
+var layerNames = map[Layer]string{
+	LayerECB:    "ECB",
+	LayerCBC:    "CBC",
+	LayerCBCInv: "CBC^-1",
+	LayerCFB:    "CFB",
+	LayerOFB:    "OFB",
+}
+
+func (l Layer) String() string {
+	if s, ok := layerNames[l]; ok {
+		return s
+	}
+	return "Layer(?)"
+}
+
+type layerMode struct {
+	l       Layer
+	b       cipher.Block
+	reg     []byte
+	tmp     []byte
+	decrypt bool
+}
+
+func newLayer(l Layer, b cipher.Block, iv []byte, decrypt bool) *layerMode {
+	if _, ok := layerNames[l]; !ok {
+		panic("cbc3: unknown layer")
+	}
+	bs := b.BlockSize()
+	if l != LayerECB && len(iv) != bs {
+		panic("cbc3: IV length must equal the cipher block size")
+	}
+	return &layerMode{l: l, b: b, reg: dup(iv), tmp: make([]byte, bs), decrypt: decrypt}
+}
+
+// NewLayerEncrypter returns a BlockMode which runs the single mode l over b
+// in the encryption direction.  The IV is one block, and is ignored for ECB.
+func NewLayerEncrypter(l Layer, b cipher.Block, iv []byte) cipher.BlockMode {
+	return newLayer(l, b, iv, false)
+}
+
+// NewLayerDecrypter returns a BlockMode which undoes NewLayerEncrypter.
+func NewLayerDecrypter(l Layer, b cipher.Block, iv []byte) cipher.BlockMode {
+	return newLayer(l, b, iv, true)
+}
+
+func (x *layerMode) BlockSize() int { return len(x.tmp) }
+
+func (x *layerMode) CryptBlocks(dst, src []byte) {
+	bs := len(x.tmp)
+	if len(src)%bs != 0 {
+		panic("crypto/cipher: input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("crypto/cipher: invalid buffer overlap")
+	}
+	// Encrypting in CBC and decrypting in CBC^-1 are the same operation,
+	// and so are the reverse.
+	forward := x.decrypt == (x.l == LayerCBCInv)
+	for len(src) > 0 {
+		d, s := dst[:bs], src[:bs]
+		switch x.l {
+		case LayerECB:
+			if x.decrypt {
+				x.b.Decrypt(d, s)
+			} else {
+				x.b.Encrypt(d, s)
+			}
+		case LayerCBC, LayerCBCInv:
+			if forward {
+				xorBytes(d, s, x.reg)
+				x.b.Encrypt(d, d)
+				copy(x.reg, d)
+			} else {
+				copy(x.tmp, s)
+				x.b.Decrypt(d, s)
+				xorBytes(d, d, x.reg)
+				copy(x.reg, x.tmp)
+			}
+		case LayerCFB:
+			x.b.Encrypt(x.tmp, x.reg)
+			if x.decrypt {
+				copy(x.reg, s)
+				xorBytes(d, s, x.tmp)
+			} else {
+				xorBytes(d, s, x.tmp)
+				copy(x.reg, d)
+			}
+		case LayerOFB:
+			x.b.Encrypt(x.reg, x.reg)
+			xorBytes(d, s, x.reg)
+		}
+		src, dst = src[bs:], dst[bs:]
+	}
+}
+
+// TripleMode names the three layers of a triple mode, from the plaintext
+// side.
+type TripleMode [3]Layer
+
+var errTripleMode = errors.New("cbc3: unknown triple mode")
+
+// ParseTripleMode parses a name such as "CBC|CBC^-1|CBC" or "ECB|CBC|CBC".
+// The inverse may also be written with a superscript, CBC⁻¹.  Case is
+// ignored.
+func ParseTripleMode(name string) (TripleMode, error) {
+	var m TripleMode
+	parts := strings.Split(name, "|")
+	if len(parts) != 3 {
+		return m, errTripleMode
+	}
+	for i, p := range parts {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		p = strings.Replace(p, "⁻¹", "^-1", 1)
+		for l, s := range layerNames {
+			if p == s {
+				m[i] = l
+			}
+		}
+		if m[i] == 0 {
+			return m, errTripleMode
+		}
+	}
+	return m, nil
+}
+
+// TripleModes lists every triple mode which can be built from the five
+// layers, 125 in all, in a fixed order.
+func TripleModes() []TripleMode {
+	var ms []TripleMode
+	for a := LayerECB; a <= LayerOFB; a++ {
+		for b := LayerECB; b <= LayerOFB; b++ {
+			for c := LayerECB; c <= LayerOFB; c++ {
+				ms = append(ms, TripleMode{a, b, c})
+			}
+		}
+	}
+	return ms
+}
+
+func (m TripleMode) String() string {
+	return m[0].String() + "|" + m[1].String() + "|" + m[2].String()
+}
+
+// NewEncrypter returns a BlockMode which encrypts in the triple mode m, layer
+// i running around block bi.  The IV is three blocks, register i being the IV
+// of layer i; the registers of ECB layers are ignored.
+func (m TripleMode) NewEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	return m.newCascade(b1, b2, b3, iv, false)
+}
+
+// NewDecrypter returns a BlockMode which decrypts in the triple mode m.
+func (m TripleMode) NewDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	return m.newCascade(b1, b2, b3, iv, true)
+}
+
+func (m TripleMode) newCascade(b1, b2, b3 cipher.Block, iv []byte, decrypt bool) cipher.BlockMode {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		panic("cbc3.TripleMode: BlockSize must be equal for all three block ciphers")
+	}
+	if len(iv) != 3*bs {
+		panic("cbc3.TripleMode: IV length must equal three times the cipher block size")
+	}
+	var layers []cipher.BlockMode
+	for i, b := range []cipher.Block{b1, b2, b3} {
+		layers = append(layers, newLayer(m[i], b, iv[i*bs:(i+1)*bs], decrypt))
+	}
+	if decrypt {
+		return ComposeDecrypter(layers...)
+	}
+	return Compose(layers...)
+}
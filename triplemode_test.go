@@ -0,0 +1,114 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func TestTripleModeNames(t *testing.T) {
+	for _, name := range []string{"CBC|CBC^-1|CBC", "cbc | CBC⁻¹ | cbc"} {
+		m, err := cbc3.ParseTripleMode(name)
+		if err != nil {
+			t.Fatalf("%q: %s", name, err)
+		}
+		if m != (cbc3.TripleMode{cbc3.LayerCBC, cbc3.LayerCBCInv, cbc3.LayerCBC}) {
+			t.Errorf("%q parsed as %s", name, m)
+		}
+	}
+	for _, name := range []string{"", "CBC|CBC", "CBC|CTR|CBC", "CBC|CBC|CBC|CBC"} {
+		if _, err := cbc3.ParseTripleMode(name); err == nil {
+			t.Errorf("%q was accepted", name)
+		}
+	}
+	modes := cbc3.TripleModes()
+	if len(modes) != 125 {
+		t.Fatalf("got %d modes, want 125", len(modes))
+	}
+	for _, m := range modes {
+		if p, err := cbc3.ParseTripleMode(m.String()); err != nil || p != m {
+			t.Errorf("%s does not survive its name", m)
+		}
+	}
+}
+
+func TestTripleModeIsCBC3(t *testing.T) {
+	m, _ := cbc3.ParseTripleMode("CBC|CBC^-1|CBC")
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	iv := benchkey[:24]
+	msg := bytes.Repeat([]byte("triple modes"), 10)[:96]
+
+	want := make([]byte, len(msg))
+	cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, msg)
+	got := make([]byte, len(msg))
+	m.NewEncrypter(b1, b2, b3, iv).CryptBlocks(got, msg)
+	if !bytes.Equal(got, want) {
+		t.Errorf("CBC|CBC^-1|CBC differs from CBC3")
+	}
+}
+
+func TestLayersAgainstStdlib(t *testing.T) {
+	b, _ := aes.NewCipher(benchkey[:16])
+	iv := benchkey[16:32]
+	msg := bytes.Repeat([]byte("0123456789abcdef"), 5)
+
+	for _, tc := range []struct {
+		l    cbc3.Layer
+		want func(dst, src []byte)
+	}{
+		{cbc3.LayerCBC, cipher.NewCBCEncrypter(b, iv).CryptBlocks},
+		{cbc3.LayerCBCInv, cipher.NewCBCDecrypter(b, iv).CryptBlocks},
+		{cbc3.LayerCFB, cipher.NewCFBEncrypter(b, iv).XORKeyStream},
+		{cbc3.LayerOFB, cipher.NewOFB(b, iv).XORKeyStream},
+	} {
+		want, got := make([]byte, len(msg)), make([]byte, len(msg))
+		tc.want(want, msg)
+		enc := cbc3.NewLayerEncrypter(tc.l, b, iv)
+		enc.CryptBlocks(got[:32], msg[:32])
+		enc.CryptBlocks(got[32:], msg[32:])
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the standard library", tc.l)
+		}
+	}
+}
+
+func TestTripleModesRoundTrip(t *testing.T) {
+	d1, _ := des.NewCipher(benchkey[:8])
+	d2, _ := des.NewCipher(benchkey[8:16])
+	d3, _ := des.NewCipher(benchkey[16:24])
+	a1, _ := aes.NewCipher(benchkey[:16])
+	a2, _ := aes.NewCipher(benchkey[8:24])
+	a3, _ := aes.NewCipher(benchkey[16:32])
+	msg := bytes.Repeat([]byte("round trip "), 16)[:144]
+
+	for _, m := range cbc3.TripleModes() {
+		for _, bl := range [][3]cipher.Block{{d1, d2, d3}, {a1, a2, a3}} {
+			bs := bl[0].BlockSize()
+			iv := bytes.Repeat(benchkey[:bs], 3)
+			for i := range iv {
+				iv[i] ^= byte(i)
+			}
+			ct := make([]byte, len(msg))
+			enc := m.NewEncrypter(bl[0], bl[1], bl[2], iv)
+			enc.CryptBlocks(ct[:3*bs], msg[:3*bs])
+			enc.CryptBlocks(ct[3*bs:], msg[3*bs:])
+			if bytes.Equal(ct, msg) {
+				t.Errorf("%s/%d: ciphertext equals plaintext", m, bs)
+			}
+
+			pt := dup(ct)
+			dec := m.NewDecrypter(bl[0], bl[1], bl[2], iv)
+			dec.CryptBlocks(pt[:bs], pt[:bs])
+			dec.CryptBlocks(pt[bs:], pt[bs:])
+			if !bytes.Equal(pt, msg) {
+				t.Errorf("%s/%d: round trip failed", m, bs)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbc3
+
+import "crypto/cipher"
+
+// CryptBlocksVec encrypts or decrypts the concatenation of the src fragments
+// into the concatenation of the dst fragments, as if both had been joined into
+// contiguous slices and passed to mode.CryptBlocks.  The fragments need not be
+// block aligned.  Runs of whole blocks which lie inside a single source and
+// destination fragment are handed to the mode directly; only a block which
+// straddles a fragment boundary is staged through a one block buffer.  The
+// chaining state of the mode, including all three CBC3 layers, carries across
+// fragments just as it does across calls to CryptBlocks.
+//
+// The total length of src must be a multiple of the block size and dst must
+// hold at least as many bytes.  As with CryptBlocks, dst and src may be the
+// same fragments to work in-place, but must not otherwise overlap.
+func CryptBlocksVec(mode cipher.BlockMode, dst, src [][]byte) {
+	bs := mode.BlockSize()
+	total, room := 0, 0
+	for _, s := range src {
+		total += len(s)
+	}
+	for _, d := range dst {
+		room += len(d)
+	}
+	if total%bs != 0 {
+		panic("crypto/cipher: input not full blocks")
+	}
+	if room < total {
+		panic("crypto/cipher: output smaller than input")
+	}
+
+	var s, d, stage []byte
+	for total > 0 {
+		for len(s) == 0 {
+			s, src = src[0], src[1:]
+		}
+		for len(d) == 0 {
+			d, dst = dst[0], dst[1:]
+		}
+
+		n := len(s)
+		if len(d) < n {
+			n = len(d)
+		}
+		n -= n % bs
+		if n > 0 {
+			mode.CryptBlocks(d[:n], s[:n])
+			s, d = s[n:], d[n:]
+			total -= n
+			continue
+		}
+
+		// The next block straddles a fragment boundary.
+		if stage == nil {
+			stage = make([]byte, bs)
+		}
+		for i := 0; i < bs; {
+			for len(s) == 0 {
+				s, src = src[0], src[1:]
+			}
+			c := copy(stage[i:], s)
+			s = s[c:]
+			i += c
+		}
+		mode.CryptBlocks(stage, stage)
+		for i := 0; i < bs; {
+			for len(d) == 0 {
+				d, dst = dst[0], dst[1:]
+			}
+			c := copy(d, stage[i:])
+			d = d[c:]
+			i += c
+		}
+		total -= bs
+	}
+}
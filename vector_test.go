@@ -0,0 +1,74 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/des"
+	"math/rand"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+// fragment splits buf into randomly sized pieces, including empty ones.
+func fragment(rng *rand.Rand, buf []byte) [][]byte {
+	var frags [][]byte
+	for len(buf) > 0 {
+		n := rng.Intn(20)
+		if n > len(buf) {
+			n = len(buf)
+		}
+		frags = append(frags, buf[:n])
+		buf = buf[n:]
+	}
+	return append(frags, nil)
+}
+
+func TestCryptBlocksVec(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	b2, _ := des.NewCipher(benchkey[8:16])
+	b3, _ := des.NewCipher(benchkey[16:24])
+	iv := []byte("0123456789abcdefghijklmn")
+	rng := rand.New(rand.NewSource(1))
+
+	for trial := 0; trial < 200; trial++ {
+		plaintext := make([]byte, 8*rng.Intn(40))
+		rng.Read(plaintext)
+
+		want := make([]byte, len(plaintext))
+		cbc3.NewEncrypter(b1, b2, b3, iv).CryptBlocks(want, plaintext)
+
+		got := make([]byte, len(plaintext))
+		cbc3.CryptBlocksVec(cbc3.NewEncrypter(b1, b2, b3, iv), fragment(rng, got), fragment(rng, plaintext))
+		if !bytes.Equal(got, want) {
+			t.Fatalf("trial %d: vectored encryption differs", trial)
+		}
+
+		// Decrypt in-place over the same fragment list.
+		frags := fragment(rng, got)
+		cbc3.CryptBlocksVec(cbc3.NewDecrypter(b1, b2, b3, iv), frags, frags)
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("trial %d: vectored in-place decryption differs", trial)
+		}
+	}
+}
+
+func TestCryptBlocksVecPanics(t *testing.T) {
+	b1, _ := des.NewCipher(benchkey[:8])
+	mode := cbc3.NewEncrypter(b1, b1, b1, make([]byte, 24))
+	for _, tc := range []struct {
+		name     string
+		dst, src [][]byte
+	}{
+		{"partial block", [][]byte{make([]byte, 16)}, [][]byte{make([]byte, 5), make([]byte, 6)}},
+		{"short output", [][]byte{make([]byte, 8)}, [][]byte{make([]byte, 8), make([]byte, 8)}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", tc.name)
+				}
+			}()
+			cbc3.CryptBlocksVec(mode, tc.dst, tc.src)
+		}()
+	}
+}
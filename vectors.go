@@ -0,0 +1,157 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Known answer test vectors, stored as JSON with all byte strings in hex.
+// The corpus in testdata/vectors.json is made by cmd/cbc3-vectors from the
+// standard library reference implementation and checked by the tests against
+// the optimized one.
+
+package cbc3
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+)
+
+// HexBytes is a byte slice which is written to JSON as a hex string.
+type HexBytes []byte
+
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// vectorPattern is the direction pattern of the three CBC3 stages, the only
+// one the vectors currently describe.
+const vectorPattern = "EDE"
+
+// Vector is a CBC3 known answer test.  Stage1 and Stage2 optionally hold the
+// output of the first and second stage layer for each block, in the
+// encryption direction, so that a failing implementation can be narrowed down
+// to one layer; the output of the third is the ciphertext.
+type Vector struct {
+	Name       string      `json:"name"`
+	Algorithms [3]string   `json:"algorithms"` // registered names, see LookupAlgorithm
+	Keys       [3]HexBytes `json:"keys"`
+	IV         HexBytes    `json:"iv"`
+	Pattern    string      `json:"pattern"` // stage directions, "EDE"
+	Plaintext  HexBytes    `json:"plaintext"`
+	Ciphertext HexBytes    `json:"ciphertext"`
+	Stage1     HexBytes    `json:"stage1,omitempty"`
+	Stage2     HexBytes    `json:"stage2,omitempty"`
+}
+
+// NewVector computes a vector, with intermediates, using the standard library
+// CBC modes layer by layer as in NewReferenceEncrypter.
+func NewVector(name string, algs [3]string, keys [3][]byte, iv, plaintext []byte) (*Vector, error) {
+	v := &Vector{Name: name, Algorithms: algs, IV: dup(iv), Pattern: vectorPattern, Plaintext: dup(plaintext)}
+	for i := range keys {
+		v.Keys[i] = dup(keys[i])
+	}
+	b1, b2, b3, err := v.blocks()
+	if err != nil {
+		return nil, err
+	}
+	bs := b1.BlockSize()
+	if len(plaintext)%bs != 0 {
+		return nil, fmt.Errorf("cbc3: vector %s: plaintext is not full blocks", name)
+	}
+	v.Stage1 = make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(b1, iv[:bs]).CryptBlocks(v.Stage1, plaintext)
+	v.Stage2 = make([]byte, len(plaintext))
+	cipher.NewCBCDecrypter(b2, iv[bs:2*bs]).CryptBlocks(v.Stage2, v.Stage1)
+	v.Ciphertext = make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(b3, iv[2*bs:]).CryptBlocks(v.Ciphertext, v.Stage2)
+	return v, nil
+}
+
+func (v *Vector) blocks() (b1, b2, b3 cipher.Block, err error) {
+	if v.Pattern != vectorPattern {
+		return nil, nil, nil, fmt.Errorf("cbc3: vector %s: unsupported pattern %q", v.Name, v.Pattern)
+	}
+	var bl [3]cipher.Block
+	for i, name := range v.Algorithms {
+		a, ok := LookupAlgorithm(name)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("cbc3: vector %s: unknown algorithm %q", v.Name, name)
+		}
+		if bl[i], err = a.New(v.Keys[i]); err != nil {
+			return nil, nil, nil, fmt.Errorf("cbc3: vector %s: stage %d: %v", v.Name, i+1, err)
+		}
+	}
+	bs := bl[0].BlockSize()
+	if bs != bl[1].BlockSize() || bs != bl[2].BlockSize() {
+		return nil, nil, nil, fmt.Errorf("cbc3: vector %s: stage block sizes differ", v.Name)
+	}
+	if len(v.IV) != 3*bs {
+		return nil, nil, nil, fmt.Errorf("cbc3: vector %s: IV is %d bytes, want %d", v.Name, len(v.IV), 3*bs)
+	}
+	return bl[0], bl[1], bl[2], nil
+}
+
+// stageTracer collects the first and second stage outputs from a trace.
+type stageTracer struct{ stage1, stage2 []byte }
+
+func (s *stageTracer) TraceBlock(t *BlockTrace) {
+	s.stage1 = append(s.stage1, t.Layers[0].Out...)
+	s.stage2 = append(s.stage2, t.Layers[1].Out...)
+}
+
+// Check encrypts and decrypts the vector with NewEncrypter and NewDecrypter
+// and returns an error naming the first value that does not match.
+func (v *Vector) Check() error {
+	b1, b2, b3, err := v.blocks()
+	if err != nil {
+		return err
+	}
+	if len(v.Plaintext) != len(v.Ciphertext) || len(v.Plaintext)%b1.BlockSize() != 0 {
+		return fmt.Errorf("cbc3: vector %s: bad plaintext or ciphertext length", v.Name)
+	}
+
+	out := make([]byte, len(v.Plaintext))
+	if v.Stage1 != nil || v.Stage2 != nil {
+		// The intermediates come from the traced path, so the plain
+		// loop is run again below.
+		tr := &stageTracer{}
+		enc := NewEncrypter(b1, b2, b3, v.IV)
+		SetTracer(enc, tr)
+		enc.CryptBlocks(out, v.Plaintext)
+		if v.Stage1 != nil && !bytes.Equal(tr.stage1, v.Stage1) {
+			return fmt.Errorf("cbc3: vector %s: stage 1 output %x, want %x", v.Name, tr.stage1, []byte(v.Stage1))
+		}
+		if v.Stage2 != nil && !bytes.Equal(tr.stage2, v.Stage2) {
+			return fmt.Errorf("cbc3: vector %s: stage 2 output %x, want %x", v.Name, tr.stage2, []byte(v.Stage2))
+		}
+	}
+	NewEncrypter(b1, b2, b3, v.IV).CryptBlocks(out, v.Plaintext)
+	if !bytes.Equal(out, v.Ciphertext) {
+		return fmt.Errorf("cbc3: vector %s: ciphertext %x, want %x", v.Name, out, []byte(v.Ciphertext))
+	}
+
+	NewDecrypter(b1, b2, b3, v.IV).CryptBlocks(out, v.Ciphertext)
+	if !bytes.Equal(out, v.Plaintext) {
+		return fmt.Errorf("cbc3: vector %s: decrypted %x, want %x", v.Name, out, []byte(v.Plaintext))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cbc3_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func loadVectors(t *testing.T) []*cbc3.Vector {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vs []*cbc3.Vector
+	if err := json.Unmarshal(data, &vs); err != nil {
+		t.Fatal(err)
+	}
+	return vs
+}
+
+func TestVectors(t *testing.T) {
+	vs := loadVectors(t)
+	seen := map[string]bool{}
+	for _, v := range vs {
+		if err := v.Check(); err != nil {
+			t.Error(err)
+		}
+		for _, a := range v.Algorithms {
+			seen[a] = true
+		}
+	}
+	for _, a := range []string{"des", "aes-128", "aes-192", "aes-256", "blowfish", "cast5"} {
+		if !seen[a] {
+			t.Errorf("corpus has no %s vectors", a)
+		}
+	}
+}
+
+func TestVectorCheckFails(t *testing.T) {
+	for _, corrupt := range []func(v *cbc3.Vector){
+		func(v *cbc3.Vector) { v.Ciphertext[0] ^= 1 },
+		func(v *cbc3.Vector) { v.Stage2[len(v.Stage2)-1] ^= 1 },
+		func(v *cbc3.Vector) { v.Algorithms[1] = "rot13" },
+		func(v *cbc3.Vector) { v.Pattern = "EEE" },
+	} {
+		v := loadVectors(t)[1]
+		corrupt(v)
+		if v.Check() == nil {
+			t.Errorf("corrupted vector %s passed", v.Name)
+		}
+	}
+}
+
+func TestNewVectorSSH1(t *testing.T) {
+	// An SSH-1 style key set, K3 equal to K1 and a zero IV, over the
+	// fixture bytes.
+	ks := cbc3.SSH1KeySet([]byte("test"))
+	ct := SSH1encrypted[195:]
+	ct = ct[:len(ct)-len(ct)%8]
+	v, err := cbc3.NewVector("ssh1", [3]string{"des", "des", "des"}, [3][]byte{ks.K1, ks.K2, ks.K3}, ks.IV, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Check(); err != nil {
+		t.Error(err)
+	}
+	out, _ := json.Marshal(v)
+	var back cbc3.Vector
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	if err := back.Check(); err != nil {
+		t.Errorf("vector did not survive JSON: %s", err)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 pschou (github.com/pschou)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The ANSI X9.52 triple modes, described in NIST SP 800-20.  Unlike CBC3 these
+// are outer modes: the three blocks are first combined into one triple block,
+// E3(D2(E1(x))), and a single chaining mode runs around it.  The interleaved
+// (-I) and pipelined (-P) variants run three independent chains over every
+// third block, so that hardware can keep three operations in flight.  Their
+// three IVs are derived from the one given, I2 = I1 + R1 and I3 = I1 + R2
+// modulo 2^64 (2^n for an n bit block), with R1 = 0x5555... and R2 =
+// 0xAAAA....  Full block feedback is used for the CFB and OFB modes.
+
+package cbc3
+
+import "crypto/cipher"
+
+type tripleBlock struct {
+	b1, b2, b3 cipher.Block
+	tmp        []byte
+}
+
+// NewTripleBlock returns the cipher.Block E3(D2(E1(x))) made from three blocks
+// of equal block size, the TDEA construction of X9.52 over arbitrary ciphers.
+// With three DES blocks it is the same as des.NewTripleDESCipher.
+func NewTripleBlock(b1, b2, b3 cipher.Block) cipher.Block {
+	bs := b1.BlockSize()
+	if bs != b2.BlockSize() || bs != b3.BlockSize() {
+		panic("cbc3.NewTripleBlock: BlockSize must be equal for all three block ciphers")
+	}
+	return &tripleBlock{b1: b1, b2: b2, b3: b3, tmp: make([]byte, bs)}
+}
+
+func (t *tripleBlock) BlockSize() int { return len(t.tmp) }
+
+func (t *tripleBlock) Encrypt(dst, src []byte) {
+	t.b1.Encrypt(t.tmp, src)
+	t.b2.Decrypt(t.tmp, t.tmp)
+	t.b3.Encrypt(dst, t.tmp)
+}
+
+func (t *tripleBlock) Decrypt(dst, src []byte) {
+	t.b3.Decrypt(t.tmp, src)
+	t.b2.Encrypt(t.tmp, t.tmp)
+	t.b1.Decrypt(dst, t.tmp)
+}
+
+// NewTCBCEncrypter returns a BlockMode which encrypts in the X9.52 TCBC mode,
+// CBC around the triple block.  The IV is a single block.
+func NewTCBCEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	return cipher.NewCBCEncrypter(NewTripleBlock(b1, b2, b3), iv)
+}
+
+// NewTCBCDecrypter returns a BlockMode which decrypts in the X9.52 TCBC mode.
+func NewTCBCDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	return cipher.NewCBCDecrypter(NewTripleBlock(b1, b2, b3), iv)
+}
+
+// x952IVs derives the three interleaved IVs from I1.
+func x952IVs(iv []byte, bs int) [3][]byte {
+	if len(iv) != bs {
+		panic("cbc3: IV length must equal the cipher block size")
+	}
+	add := func(r byte) []byte {
+		out := make([]byte, bs)
+		carry := 0
+		for i := bs - 1; i >= 0; i-- {
+			s := int(iv[i]) + int(r) + carry
+			out[i] = byte(s)
+			carry = s >> 8
+		}
+		return out
+	}
+	return [3][]byte{dup(iv), add(0x55), add(0xaa)}
+}
+
+type tcbci struct {
+	b       cipher.Block
+	regs    [3][]byte
+	tmp     []byte
+	lane    int
+	decrypt bool
+}
+
+// NewTCBCIEncrypter returns a BlockMode which encrypts in the X9.52 TCBC-I
+// mode: three TCBC chains interleaved over the blocks, starting from the IVs
+// derived from the single block iv.
+func NewTCBCIEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	b := NewTripleBlock(b1, b2, b3)
+	return &tcbci{b: b, regs: x952IVs(iv, b.BlockSize()), tmp: make([]byte, b.BlockSize())}
+}
+
+// NewTCBCIDecrypter returns a BlockMode which decrypts in the X9.52 TCBC-I
+// mode.
+func NewTCBCIDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.BlockMode {
+	b := NewTripleBlock(b1, b2, b3)
+	return &tcbci{b: b, regs: x952IVs(iv, b.BlockSize()), tmp: make([]byte, b.BlockSize()), decrypt: true}
+}
+
+func (x *tcbci) BlockSize() int { return len(x.tmp) }
+
+func (x *tcbci) CryptBlocks(dst, src []byte) {
+	bs := len(x.tmp)
+	if len(src)%bs != 0 {
+		panic("crypto/cipher: input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("crypto/cipher: invalid buffer overlap")
+	}
+	for len(src) > 0 {
+		reg := x.regs[x.lane]
+		if x.decrypt {
+			copy(x.tmp, src[:bs])
+			x.b.Decrypt(dst[:bs], src[:bs])
+			xorBytes(dst[:bs], dst[:bs], reg)
+			copy(reg, x.tmp)
+		} else {
+			xorBytes(dst[:bs], src[:bs], reg)
+			x.b.Encrypt(dst[:bs], dst[:bs])
+			copy(reg, dst[:bs])
+		}
+		x.lane = (x.lane + 1) % 3
+		src, dst = src[bs:], dst[bs:]
+	}
+}
+
+// feedbackStream runs one or three full block CFB or OFB chains, switching
+// chain at every block boundary of the byte stream.
+type feedbackStream struct {
+	b       cipher.Block
+	regs    [][]byte
+	out     []byte
+	lane    int
+	off     int
+	ofb     bool
+	decrypt bool
+}
+
+func newFeedbackStream(b1, b2, b3 cipher.Block, iv []byte, interleaved, ofb, decrypt bool) cipher.Stream {
+	b := NewTripleBlock(b1, b2, b3)
+	s := &feedbackStream{b: b, out: make([]byte, b.BlockSize()), ofb: ofb, decrypt: decrypt}
+	if interleaved {
+		ivs := x952IVs(iv, b.BlockSize())
+		s.regs = ivs[:]
+	} else {
+		if len(iv) != b.BlockSize() {
+			panic("cbc3: IV length must equal the cipher block size")
+		}
+		s.regs = [][]byte{dup(iv)}
+	}
+	return s
+}
+
+// NewTCFBEncrypter returns a Stream which encrypts in the X9.52 TCFB mode
+// with full block feedback.
+func NewTCFBEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.Stream {
+	return newFeedbackStream(b1, b2, b3, iv, false, false, false)
+}
+
+// NewTCFBDecrypter returns a Stream which decrypts in the X9.52 TCFB mode
+// with full block feedback.
+func NewTCFBDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.Stream {
+	return newFeedbackStream(b1, b2, b3, iv, false, false, true)
+}
+
+// NewTCFBPEncrypter returns a Stream which encrypts in the X9.52 TCFB-P
+// mode: three pipelined TCFB chains, starting from the IVs derived from the
+// single block iv.
+func NewTCFBPEncrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.Stream {
+	return newFeedbackStream(b1, b2, b3, iv, true, false, false)
+}
+
+// NewTCFBPDecrypter returns a Stream which decrypts in the X9.52 TCFB-P
+// mode.
+func NewTCFBPDecrypter(b1, b2, b3 cipher.Block, iv []byte) cipher.Stream {
+	return newFeedbackStream(b1, b2, b3, iv, true, false, true)
+}
+
+// NewTOFB returns a Stream which encrypts or decrypts in the X9.52 TOFB mode.
+func NewTOFB(b1, b2, b3 cipher.Block, iv []byte) cipher.Stream {
+	return newFeedbackStream(b1, b2, b3, iv, false, true, false)
+}
+
+// NewTOFBI returns a Stream which encrypts or decrypts in the X9.52 TOFB-I
+// mode: three interleaved TOFB chains, starting from the IVs derived from the
+// single block iv.
+func NewTOFBI(b1, b2, b3 cipher.Block, iv []byte) cipher.Stream {
+	return newFeedbackStream(b1, b2, b3, iv, true, true, false)
+}
+
+func (s *feedbackStream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("crypto/cipher: invalid buffer overlap")
+	}
+	bs := len(s.out)
+	for i := range src {
+		reg := s.regs[s.lane]
+		if s.off == 0 {
+			s.b.Encrypt(s.out, reg)
+			if s.ofb {
+				copy(reg, s.out)
+			}
+		}
+		in := src[i]
+		dst[i] = in ^ s.out[s.off]
+		if !s.ofb {
+			// CFB feeds the ciphertext back into the register.
+			if s.decrypt {
+				reg[s.off] = in
+			} else {
+				reg[s.off] = dst[i]
+			}
+		}
+		if s.off++; s.off == bs {
+			s.off = 0
+			s.lane = (s.lane + 1) % len(s.regs)
+		}
+	}
+}
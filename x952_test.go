@@ -0,0 +1,318 @@
+package cbc3_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	cbc3 "github.com/pschou/go-cbc3"
+)
+
+func x952Blocks(t *testing.T, k1, k2, k3 []byte) (b1, b2, b3 cipher.Block) {
+	b1, err := des.NewCipher(k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, _ = des.NewCipher(k2)
+	b3, _ = des.NewCipher(k3)
+	return
+}
+
+// x952Modes makes each of the six modes from three blocks, as encrypt and
+// decrypt functions over whole blocks.  The OFB modes use one stream for both.
+func x952Modes(b1, b2, b3 cipher.Block, iv []byte) map[string][2]func(dst, src []byte) {
+	return map[string][2]func(dst, src []byte){
+		"TCBC":   {cbc3.NewTCBCEncrypter(b1, b2, b3, iv).CryptBlocks, cbc3.NewTCBCDecrypter(b1, b2, b3, iv).CryptBlocks},
+		"TCBC-I": {cbc3.NewTCBCIEncrypter(b1, b2, b3, iv).CryptBlocks, cbc3.NewTCBCIDecrypter(b1, b2, b3, iv).CryptBlocks},
+		"TCFB":   {cbc3.NewTCFBEncrypter(b1, b2, b3, iv).XORKeyStream, cbc3.NewTCFBDecrypter(b1, b2, b3, iv).XORKeyStream},
+		"TCFB-P": {cbc3.NewTCFBPEncrypter(b1, b2, b3, iv).XORKeyStream, cbc3.NewTCFBPDecrypter(b1, b2, b3, iv).XORKeyStream},
+		"TOFB":   {cbc3.NewTOFB(b1, b2, b3, iv).XORKeyStream, cbc3.NewTOFB(b1, b2, b3, iv).XORKeyStream},
+		"TOFB-I": {cbc3.NewTOFBI(b1, b2, b3, iv).XORKeyStream, cbc3.NewTOFBI(b1, b2, b3, iv).XORKeyStream},
+	}
+}
+
+// TestX952KnownAnswer runs the tables in testdata/sp800-20.txt through all six
+// modes the way SP 800-20 does.  TCBC and TCBC-I take the table input as
+// plaintext under a zero IV.  The feedback modes take it as the IV with a zero
+// plaintext, so their first block of keystream is the table output.
+func TestX952KnownAnswer(t *testing.T) {
+	data, err := os.ReadFile("testdata/sp800-20.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero := make([]byte, 8)
+	count := map[string]int{}
+	for n, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if len(f) != 4 {
+			t.Fatalf("line %d: want 4 fields, got %d", n+1, len(f))
+		}
+		key, in, want := hexKey(f[1]), hexKey(f[2]), hexKey(f[3])
+		if len(key) == 8 {
+			key = bytes.Repeat(key, 3)
+		}
+		b1, b2, b3 := x952Blocks(t, key[:8], key[8:16], key[16:])
+		count[f[0]]++
+
+		for _, name := range []string{"TCBC", "TCBC-I", "TCFB", "TCFB-P", "TOFB", "TOFB-I"} {
+			iv, pt, ct := in, zero, want
+			if name == "TCBC" || name == "TCBC-I" {
+				iv, pt = zero, in
+			}
+			m := x952Modes(b1, b2, b3, iv)[name]
+			got := make([]byte, 8)
+			if m[0](got, pt); !bytes.Equal(got, ct) {
+				t.Errorf("%s %s %s: %s gave %x, want %x", f[0], f[1], f[2], name, got, ct)
+			}
+			if m[1](got, ct); !bytes.Equal(got, pt) {
+				t.Errorf("%s %s %s: %s decrypted to %x, want %x", f[0], f[1], f[2], name, got, pt)
+			}
+		}
+	}
+	for table, n := range map[string]int{"A.1": 64, "A.2": 56, "A.3": 32, "A.4": 19} {
+		if count[table] != n {
+			t.Errorf("table %s has %d entries, want %d", table, count[table], n)
+		}
+	}
+	if count["TDES"] == 0 {
+		t.Errorf("no tests with distinct keys")
+	}
+}
+
+// x952RefIVs derives the interleaved IVs with big integer arithmetic.
+func x952RefIVs(iv []byte) [3][]byte {
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(8*len(iv)))
+	i1 := new(big.Int).SetBytes(iv)
+	var out [3][]byte
+	for i, r := range []byte{0, 0x55, 0xaa} {
+		v := new(big.Int).SetBytes(bytes.Repeat([]byte{r}, len(iv)))
+		v.Add(v, i1).Mod(v, mod)
+		out[i] = make([]byte, len(iv))
+		v.FillBytes(out[i])
+	}
+	return out
+}
+
+// deinterleave runs lane i of f over every third block of src.
+func deinterleave(src []byte, bs int, f [3]func(dst, src []byte)) []byte {
+	dst := make([]byte, len(src))
+	for i := 0; i*bs < len(src); i++ {
+		end := (i + 1) * bs
+		if end > len(src) {
+			end = len(src)
+		}
+		f[i%3](dst[i*bs:end], src[i*bs:end])
+	}
+	return dst
+}
+
+func TestX952AgainstStdlib(t *testing.T) {
+	k1, k2, k3 := hexKey("0123456789abcdef"), hexKey("23456789abcdef01"), hexKey("456789abcdef0123")
+	b1, b2, b3 := x952Blocks(t, k1, k2, k3)
+	tdes, _ := des.NewTripleDESCipher(append(append(dup(k1), k2...), k3...))
+	iv := hexKey("f69f2445df4f9b17")
+	ivs := x952RefIVs(iv)
+	msg := make([]byte, 8*11)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+
+	got, want := make([]byte, len(msg)), make([]byte, len(msg))
+	cbc3.NewTCBCEncrypter(b1, b2, b3, iv).CryptBlocks(got, msg)
+	cipher.NewCBCEncrypter(tdes, iv).CryptBlocks(want, msg)
+	if !bytes.Equal(got, want) {
+		t.Errorf("TCBC differs from CBC over TripleDES")
+	}
+	cbc3.NewTCBCDecrypter(b1, b2, b3, iv).CryptBlocks(got, want)
+	if !bytes.Equal(got, msg) {
+		t.Errorf("TCBC round trip failed")
+	}
+
+	var encLanes, cfbLanes, ofbLanes [3]func(dst, src []byte)
+	for i := range ivs {
+		encLanes[i] = cipher.NewCBCEncrypter(tdes, ivs[i]).CryptBlocks
+		cfbLanes[i] = cipher.NewCFBEncrypter(tdes, ivs[i]).XORKeyStream
+		ofbLanes[i] = cipher.NewOFB(tdes, ivs[i]).XORKeyStream
+	}
+
+	want = deinterleave(msg, 8, encLanes)
+	enc := cbc3.NewTCBCIEncrypter(b1, b2, b3, iv)
+	enc.CryptBlocks(got[:24], msg[:24])
+	enc.CryptBlocks(got[24:], msg[24:])
+	if !bytes.Equal(got, want) {
+		t.Errorf("TCBC-I differs from three interleaved CBC chains")
+	}
+	dec := cbc3.NewTCBCIDecrypter(b1, b2, b3, iv)
+	dec.CryptBlocks(got[:40], want[:40])
+	dec.CryptBlocks(got[40:], want[40:])
+	if !bytes.Equal(got, msg) {
+		t.Errorf("TCBC-I round trip failed")
+	}
+
+	// Use a message ending in a partial block, fed in odd sized pieces.
+	msg = msg[:len(msg)-3]
+	feed := func(s cipher.Stream, src []byte) []byte {
+		dst := make([]byte, len(src))
+		for i := 0; i < len(src); i += 5 {
+			end := i + 5
+			if end > len(src) {
+				end = len(src)
+			}
+			s.XORKeyStream(dst[i:end], src[i:end])
+		}
+		return dst
+	}
+	for _, tc := range []struct {
+		name     string
+		enc, dec cipher.Stream
+		want     []byte
+	}{
+		{"TCFB", cbc3.NewTCFBEncrypter(b1, b2, b3, iv), cbc3.NewTCFBDecrypter(b1, b2, b3, iv), feed(cipher.NewCFBEncrypter(tdes, iv), msg)},
+		{"TCFB-P", cbc3.NewTCFBPEncrypter(b1, b2, b3, iv), cbc3.NewTCFBPDecrypter(b1, b2, b3, iv), deinterleave(msg, 8, cfbLanes)},
+		{"TOFB", cbc3.NewTOFB(b1, b2, b3, iv), cbc3.NewTOFB(b1, b2, b3, iv), feed(cipher.NewOFB(tdes, iv), msg)},
+		{"TOFB-I", cbc3.NewTOFBI(b1, b2, b3, iv), cbc3.NewTOFBI(b1, b2, b3, iv), deinterleave(msg, 8, ofbLanes)},
+	} {
+		ct := feed(tc.enc, msg)
+		if !bytes.Equal(ct, tc.want) {
+			t.Errorf("%s differs from the standard library reference", tc.name)
+		}
+		if pt := feed(tc.dec, ct); !bytes.Equal(pt, msg) {
+			t.Errorf("%s round trip failed", tc.name)
+		}
+	}
+}
+
+func TestX952IVCarry(t *testing.T) {
+	ivs := x952RefIVs(hexKey("ffffffffffffffff"))
+	if !bytes.Equal(ivs[1], hexKey("5555555555555554")) || !bytes.Equal(ivs[2], hexKey("aaaaaaaaaaaaaaa9")) {
+		t.Fatalf("reference IV derivation is wrong: %x %x", ivs[1], ivs[2])
+	}
+	k := hexKey("0123456789abcdef")
+	b1, b2, b3 := x952Blocks(t, k, k, k)
+	tdes, _ := des.NewTripleDESCipher(bytes.Repeat(k, 3))
+	got, want := make([]byte, 24), make([]byte, 24)
+	cbc3.NewTCBCIEncrypter(b1, b2, b3, hexKey("ffffffffffffffff")).CryptBlocks(got, make([]byte, 24))
+	for i := range ivs {
+		tdes.Encrypt(want[8*i:], ivs[i])
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("TCBC-I lanes do not start from I1, I1+R1 and I1+R2")
+	}
+}
+
+// x952MCT runs the SP 800-20 Monte Carlo test over a mode made by newMode,
+// taking steps of w bytes: one block, or one block of each chain for the
+// interleaved and pipelined modes.  Each inner step encrypts the output of the
+// step before the last, or the starting chaining values in the second step,
+// and after every inner loop the keys are XORed with the last three output
+// blocks.  The next round starts from the last output block as IV.  It returns
+// the final output of each outer round.
+func x952MCT(newMode func(k1, k2, k3, iv []byte) func(dst, src []byte), w int, key, iv, pt []byte, outer, inner int) [][]byte {
+	k1, k2, k3 := dup(key[:8]), dup(key[8:16]), dup(key[16:])
+	cv, p := dup(iv), dup(pt)
+	var results [][]byte
+	for i := 0; i < outer; i++ {
+		crypt := newMode(k1, k2, k3, cv)
+		var prev []byte
+		if w == 8 {
+			prev = cv
+		} else {
+			ivs := x952RefIVs(cv)
+			prev = bytes.Join(ivs[:], nil)
+		}
+		var c, c1, c2 []byte
+		for j := 0; j < inner; j++ {
+			c2, c1, c = c1, c, make([]byte, w)
+			crypt(c, p)
+			p, prev = prev, c
+		}
+		results = append(results, c)
+		last := bytes.Join([][]byte{c2, c1, c}, nil)
+		last = last[len(last)-24:]
+		xor := func(k, c []byte) {
+			for n := range k {
+				k[n] ^= c[n]
+			}
+		}
+		xor(k1, last[16:])
+		xor(k2, last[8:16])
+		xor(k3, last[:8])
+		cv, p = dup(c[w-8:]), c1
+	}
+	return results
+}
+
+func TestX952MonteCarlo(t *testing.T) {
+	outer, inner := 400, 10000
+	if testing.Short() {
+		outer, inner = 4, 1000
+	}
+	key := hexKey("0123456789abcdef23456789abcdef01456789abcdef0123")
+	iv := hexKey("f69f2445df4f9b17")
+	pt := hexKey("4e6f77206973207468652074696d6520666f7220616c6c20")
+
+	ours := func(t *testing.T, name string) func(k1, k2, k3, iv []byte) func(dst, src []byte) {
+		return func(k1, k2, k3, iv []byte) func(dst, src []byte) {
+			b1, b2, b3 := x952Blocks(t, k1, k2, k3)
+			return x952Modes(b1, b2, b3, iv)[name][0]
+		}
+	}
+	// The references build each mode from the standard library, with
+	// three separate chains for the interleaved and pipelined modes.
+	ref := func(lane func(b cipher.Block, iv []byte) func(dst, src []byte), lanes bool) func(k1, k2, k3, iv []byte) func(dst, src []byte) {
+		return func(k1, k2, k3, iv []byte) func(dst, src []byte) {
+			tdes, _ := des.NewTripleDESCipher(append(append(dup(k1), k2...), k3...))
+			if !lanes {
+				return lane(tdes, iv)
+			}
+			var f [3]func(dst, src []byte)
+			for i, iv := range x952RefIVs(iv) {
+				f[i] = lane(tdes, iv)
+			}
+			return func(dst, src []byte) { copy(dst, deinterleave(src, 8, f)) }
+		}
+	}
+	cbc := func(b cipher.Block, iv []byte) func(dst, src []byte) {
+		return cipher.NewCBCEncrypter(b, iv).CryptBlocks
+	}
+	cfb := func(b cipher.Block, iv []byte) func(dst, src []byte) {
+		return cipher.NewCFBEncrypter(b, iv).XORKeyStream
+	}
+	ofb := func(b cipher.Block, iv []byte) func(dst, src []byte) { return cipher.NewOFB(b, iv).XORKeyStream }
+
+	for _, tc := range []struct {
+		name string
+		ref  func(k1, k2, k3, iv []byte) func(dst, src []byte)
+		w    int
+	}{
+		{"TCBC", ref(cbc, false), 8},
+		{"TCBC-I", ref(cbc, true), 24},
+		{"TCFB", ref(cfb, false), 8},
+		{"TCFB-P", ref(cfb, true), 24},
+		{"TOFB", ref(ofb, false), 8},
+		{"TOFB-I", ref(ofb, true), 24},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got := x952MCT(ours(t, tc.name), tc.w, key, iv, pt[:tc.w], outer, inner)
+			want := x952MCT(tc.ref, tc.w, key, iv, pt[:tc.w], outer, inner)
+			for i := range want {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Fatalf("round %d: got %x, want %x", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func dup(p []byte) []byte {
+	return append([]byte{}, p...)
+}